@@ -42,6 +42,13 @@ var addCmd = &cobra.Command{
 		// Check if user is root.
 		util.CheckRoot("Please run rpkgm add as root.")
 
+		// Make sure no other rpkgm changes the system at the same time
+		lockState()
+		defer unlockState()
+
+		// What the repositories' databases say is installed is kept before they are opened
+		keepInstalledState()
+
 		deps := ""
 		if len(dependencies) > 0 {
 			// Convert the dependencies list into a string
@@ -50,7 +57,7 @@ var addCmd = &cobra.Command{
 
 		// Decide what to do and do what is needed to do
 		add.Decide(
-			repoDB,
+			primaryRepo().Path,
 			name,
 			description,
 			version,
@@ -76,7 +83,7 @@ func init() { //nolint:gochecknoinits
 
 	// Optional flag to specify build files location
 	addCmd.Flags().
-		StringVarP(&buildFilesDir, "files", "f", "", "Build files location.")
+		StringVarP(&buildFilesDir, "files", "f", "", "Build files directory, relative to the repo's directory in the cache (defaults to the package's name).")
 
 	// Optional flag to give a description of a package
 	addCmd.Flags().
@@ -93,12 +100,12 @@ func init() { //nolint:gochecknoinits
 
 	// Flag for a package's dependencies
 	addCmd.Flags().
-		StringSliceVar(&dependencies, "deps", nil, "List of dependencies separated by a commas.")
+		StringSliceVar(&dependencies, "deps", nil, "List of dependencies separated by a commas, optionally with a version constraint (ex: zlib>=1.2.13,openssl<3,foo=1.4.*).")
 
 	// Flag to import a json file containing the record to add to the repo's db
 	addCmd.Flags().StringVarP(&importFile, "import", "i", "", "JSON file to import to the repo.")
 
 	// Optional flag to specify repo database location
 	addCmd.Flags().
-		StringVarP(&repoDB, "repo", "r", "var/rpkgm/main/main.db", "Specify repo Database location.")
+		StringVarP(&repoDB, "repo", "r", "", "Specify repo Database location (defaults to the highest priority repository's).")
 }
@@ -0,0 +1,61 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"github.com/redds-be/rpkgm/internal/autoremove"
+	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/spf13/cobra"
+)
+
+// autoremoveCmd represents the autoremove command.
+var autoremoveCmd = &cobra.Command{
+	Use:   "autoremove",
+	Short: "Uninstall packages that were only installed as dependencies and are not needed anymore.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Check if user is root.
+		util.CheckRoot("Please run rpkgm autoremove as root.")
+
+		// Make sure no other rpkgm changes the system at the same time
+		lockState()
+		defer unlockState()
+
+		// Decide what to do and do what is needed to do
+		autoremove.Decide(dbLocation, verbose, keep, yes)
+	},
+}
+
+// init initializes the command-line arguments for cobra.
+func init() { //nolint:gochecknoinits
+	// Link to root (root = 'rpkgm', autoremove = 'rpkgm autoremove')
+	rootCmd.AddCommand(autoremoveCmd)
+
+	// Flag for verbosity
+	autoremoveCmd.Flags().
+		BoolVarP(&verbose, "verbose", "v", false, "Make rpkgm verbose during operation.")
+
+	// Flag to indicate there is no need for confirmation
+	autoremoveCmd.Flags().BoolVarP(&yes, "yes", "y", false, "Do not ask before uninstalling.")
+
+	// Flag for keeping packages source dir intact
+	autoremoveCmd.Flags().
+		BoolVarP(&keep, "keep", "k", false, "Keep package(s) source directories after uninstallation (/usr/src/rpkgm/<pkgName>)")
+
+	// Optional flag to only use a given repo database instead of the configured repositories
+	autoremoveCmd.Flags().
+		StringVarP(&repoDB, "repo", "r", "", "Only use the repo database at the given location instead of the configured repositories.")
+}
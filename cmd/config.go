@@ -0,0 +1,174 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/redds-be/rpkgm/internal/config"
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/logging"
+	"github.com/redds-be/rpkgm/internal/pkg"
+	"github.com/redds-be/rpkgm/internal/sync"
+	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/spf13/cobra"
+)
+
+// cfg holds the effective configuration of the running command.
+var cfg *config.Config
+
+// configFlags maps the command-line flags to the configuration keys they override, in the order they are applied.
+var configFlags = [][2]string{
+	{"root", "root"},
+	{"dbpath", "db_path"},
+	{"cachedir", "cache_dir"},
+	{"yes", "yes"},
+	{"keep", "keep"},
+	{"verbose", "verbose"},
+}
+
+// configCmd represents the config command.
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration of rpkgm.",
+}
+
+// configShowCmd represents the config show command.
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective configuration and where each value comes from.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Show which files are read, in order
+		for _, path := range config.Files() {
+			if _, err := os.Stat(path); err != nil {
+				util.Display(os.Stdout, false, "# %s (not found)", path)
+			} else {
+				util.Display(os.Stdout, false, "# %s", path)
+			}
+		}
+		util.Display(os.Stdout, false, "")
+
+		for _, line := range cfg.Lines() {
+			util.Display(os.Stdout, false, "%s", line)
+		}
+	},
+}
+
+// loadConfig loads the configuration, lets the command-line flags override it and applies it.
+func loadConfig(cmd *cobra.Command) {
+	var err error
+
+	cfg, err = config.Load()
+	if err != nil {
+		util.Display(os.Stderr, false, "rpkgm could not load its configuration. Error: %s", err)
+		util.Exit(1)
+	}
+
+	// Flags given on the command line take precedence
+	for _, configFlag := range configFlags {
+		flagName, key := configFlag[0], configFlag[1]
+
+		flag := cmd.Flags().Lookup(flagName)
+		if flag == nil || !flag.Changed {
+			continue
+		}
+
+		err = cfg.SetFromFlag(key, flag.Value.String())
+		if err != nil {
+			util.Display(os.Stderr, false, "rpkgm could not use --%s. Error: %s", flagName, err)
+			util.Exit(1)
+		}
+	}
+
+	// Relative paths are relative to the root
+	cfg.Root, err = filepath.Abs(cfg.Root)
+	if err != nil {
+		util.Display(os.Stderr, false, "rpkgm could not get the absolute path of the root. Error: %s", err)
+		util.Exit(1)
+	}
+
+	dbLocation = database.Location{Local: cfg.Resolve(cfg.DBPath)}
+
+	// A command's --repo only uses the given repository database, named after its file
+	if flag := cmd.Flags().Lookup("repo"); flag != nil && flag.Changed {
+		repoName := strings.TrimSuffix(filepath.Base(repoDB), filepath.Ext(repoDB))
+		repo, _ := cfg.Repo(repoName)
+		dbLocation.Repos = []database.RepoLocation{{Name: repoName, Source: repo.Source(), Path: cfg.Resolve(repoDB)}}
+	} else {
+		for _, repo := range cfg.ByPriority() {
+			dbLocation.Repos = append(
+				dbLocation.Repos,
+				database.RepoLocation{Name: repo.Name, Source: repo.Source(), Path: cfg.Resolve(cfg.RepoDB(repo.Name))},
+			)
+		}
+	}
+
+	yes = cfg.Yes
+	keep = cfg.Keep
+	verbose = cfg.Verbose
+
+	logging.FilePath = cfg.Resolve(cfg.LogFile)
+	pkg.Root = cfg.Root
+	pkg.BuildDir = cfg.Resolve(cfg.BuildDir)
+	pkg.SrcDir = cfg.Resolve(cfg.SrcDir)
+	pkg.MakeArgs = cfg.MakeArgs
+	pkg.CacheDir = cfg.Resolve(cfg.CacheDir)
+	sync.CacheDir = cfg.Resolve(cfg.CacheDir)
+	sync.Keyring = trustedKeys()
+	sync.RepoKeys = make(map[string][]string, len(cfg.Repos))
+
+	for _, repo := range cfg.Repos {
+		sync.RepoKeys[repo.Name] = repo.Keys
+	}
+
+	util.DownloadTimeout = cfg.DownloadTimeout
+	util.DownloadRetries = cfg.DownloadRetries
+}
+
+// primaryRepo returns the repository commands changing a single repository work on: the one given with --repo, else
+// the highest priority one.
+func primaryRepo() database.RepoLocation {
+	if len(dbLocation.Repos) == 0 {
+		util.Display(os.Stderr, false, "No repository is configured, declare one with a [repo NAME] section.")
+		util.Exit(1)
+	}
+
+	return dbLocation.Repos[0]
+}
+
+// keepInstalledState creates the local database, if it doesn't exist yet, with what the repositories' databases say is
+// installed. It has to be done before a repository's database is opened on its own, opening it drops that state.
+func keepInstalledState() {
+	if _, err := os.Stat(dbLocation.Local); err == nil {
+		return
+	}
+
+	err := database.CreateLocal(dbLocation)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not create the local database. Error: %s", err)
+		util.Exit(1)
+	}
+}
+
+// init initializes the command-line arguments for cobra.
+func init() { //nolint:gochecknoinits
+	// Link to root (root = 'rpkgm', config = 'rpkgm config', show = 'rpkgm config show')
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
@@ -0,0 +1,67 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"github.com/redds-be/rpkgm/internal/migrate"
+	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/spf13/cobra"
+)
+
+var dryRun bool
+
+// dbCmd represents the db command.
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the local and the repos' databases.",
+}
+
+// dbMigrateCmd represents the db migrate command.
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Bring the databases' schemas up to date.",
+	Long: `Apply the pending migrations to the schemas of the local database and of the repos' databases.
+Migrations are also applied automatically whenever rpkgm opens a database.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Only check if the user is root when we're actually going to migrate.
+		if !dryRun {
+			util.CheckRoot("Please run rpkgm db migrate as root.")
+
+			// Make sure no other rpkgm changes the system at the same time
+			lockState()
+			defer unlockState()
+		}
+
+		// Decide what to do and do what is needed to do
+		migrate.Decide(dbLocation, dryRun)
+	},
+}
+
+// init initializes the command-line arguments for cobra.
+func init() { //nolint:gochecknoinits
+	// Link to root (root = 'rpkgm', db = 'rpkgm db', migrate = 'rpkgm db migrate')
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+
+	// Flag to only show the pending migrations
+	dbMigrateCmd.Flags().
+		BoolVar(&dryRun, "dry-run", false, "Only show the migrations that would be applied.")
+
+	// Optional flag to only use a given repo database instead of the configured repositories
+	dbMigrateCmd.Flags().
+		StringVarP(&repoDB, "repo", "r", "", "Only use the repo database at the given location instead of the configured repositories.")
+}
@@ -0,0 +1,199 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/redds-be/rpkgm/internal/keyring"
+	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keyName    string
+	privateKey string
+	serial     int64
+)
+
+// keyCmd represents the key command.
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manage the keys the repositories' files have to be signed with.",
+}
+
+// keyAddCmd represents the key add command.
+var keyAddCmd = &cobra.Command{
+	Use:   "add <file>",
+	Short: "Trust the public key of a repository's maintainer.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Check if user is root.
+		util.CheckRoot("Please run rpkgm key add as root.")
+
+		// Make sure no other rpkgm changes the system at the same time
+		lockState()
+		defer unlockState()
+
+		publicKey, err := keyring.ReadPublicKey(args[0])
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm could not read the public key. Error: %s", err)
+			util.Exit(1)
+		}
+
+		// The key is named after its file by default
+		if keyName == "" {
+			keyName = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+		}
+
+		err = trustedKeys().Add(keyName, publicKey)
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm could not add the key to the keyring. Error: %s", err)
+			util.Exit(1)
+		}
+
+		key := keyring.Key{Name: keyName, PublicKey: publicKey}
+		util.Display(os.Stdout, true, "Added the key %s (%s) to the keyring.", key.Name, key.Fingerprint())
+	},
+}
+
+// keyListCmd represents the key list command.
+var keyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the trusted keys along with their fingerprints.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		keys, err := trustedKeys().Keys()
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm could not read the keyring. Error: %s", err)
+			util.Exit(1)
+		}
+
+		if len(keys) == 0 {
+			util.Display(os.Stdout, false, "The keyring is empty, add a key with rpkgm key add.")
+		}
+
+		for _, key := range keys {
+			util.Display(os.Stdout, false, "%s\t%s", key.Name, key.Fingerprint())
+		}
+	},
+}
+
+// keyRemoveCmd represents the key remove command.
+var keyRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Stop trusting a key.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Check if user is root.
+		util.CheckRoot("Please run rpkgm key remove as root.")
+
+		// Make sure no other rpkgm changes the system at the same time
+		lockState()
+		defer unlockState()
+
+		err := trustedKeys().Remove(args[0])
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm could not remove the key from the keyring. Error: %s", err)
+			util.Exit(1)
+		}
+
+		util.Display(os.Stdout, true, "Removed the key %s from the keyring.", args[0])
+	},
+}
+
+// keyGenerateCmd represents the key generate command.
+var keyGenerateCmd = &cobra.Command{
+	Use:   "generate <prefix>",
+	Short: "Generate a key pair to sign a repository's files with (<prefix>.key and <prefix>.pub).",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key, err := keyring.Generate(args[0])
+		if err != nil {
+			util.Display(os.Stderr, false, "rpkgm could not generate the key pair. Error: %s", err)
+			util.Exit(1)
+		}
+
+		util.Display(
+			os.Stdout,
+			false,
+			"Generated the key %s (%s), keep %s secret and publish %s.",
+			key.Name,
+			key.Fingerprint(),
+			args[0]+keyring.PrivateKeyExt,
+			args[0]+keyring.PublicKeyExt,
+		)
+	},
+}
+
+// keySignCmd represents the key sign command.
+var keySignCmd = &cobra.Command{
+	Use:   "sign <file>...",
+	Short: "Sign a repository's files (repo.json and its archive) with a private key, writing <file>.sig.",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// The files are signed with the time by default, so that the ones signed next are newer
+		if serial == 0 {
+			serial = time.Now().Unix()
+		}
+
+		for _, path := range args {
+			err := keyring.Sign(privateKey, path, repoName, serial)
+			if err != nil {
+				util.Display(os.Stderr, false, "rpkgm could not sign %s. Error: %s", path, err)
+				util.Exit(1)
+			}
+
+			util.Display(os.Stdout, false, "Signed %s.", path)
+		}
+	},
+}
+
+// trustedKeys returns the configured keyring, it's absolute and isn't resolved against the root.
+func trustedKeys() keyring.Keyring {
+	return keyring.Keyring{Dir: cfg.KeyringDir}
+}
+
+// init initializes the command-line arguments for cobra.
+func init() { //nolint:gochecknoinits
+	// Link to root (root = 'rpkgm', key = 'rpkgm key', add = 'rpkgm key add', ...)
+	rootCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keyAddCmd, keyListCmd, keyRemoveCmd, keyGenerateCmd, keySignCmd)
+
+	// Optional flag to name the key
+	keyAddCmd.Flags().StringVarP(&keyName, "name", "n", "", "Name of the key in the keyring (defaults to the file's name without its extension).")
+
+	// Flag for the private key to sign with
+	keySignCmd.Flags().StringVarP(&privateKey, "key", "k", "", "Private key file to sign with (see rpkgm key generate).")
+
+	// Flag for the repository the files are signed for
+	keySignCmd.Flags().StringVarP(&repoName, "name", "n", "", "Name of the repository the files are signed for.")
+
+	// Flag for the serial of the signatures
+	keySignCmd.Flags().Int64Var(&serial, "serial", 0, "Serial of the signatures, older ones are refused once a newer one is synced (defaults to the time).")
+
+	// Signing needs a private key and a repository
+	for _, flag := range []string{"key", "name"} {
+		err := keySignCmd.MarkFlagRequired(flag)
+		if err != nil {
+			util.Display(os.Stderr, false, "rpkgm could not require the --%s flag. Error: %s", flag, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/redds-be/rpkgm/internal/lock"
+	"github.com/redds-be/rpkgm/internal/util"
+)
+
+// stateLock is the lock held while a command changes the system, it has to stay referenced until rpkgm exits.
+var stateLock *lock.Lock
+
+// lockState takes the lock of the state directory (the local database's) so that no other rpkgm changes the system at the same time,
+// rpkgm exits if it can't be taken. Read-only commands don't need it.
+func lockState() {
+	var err error
+
+	stateLock, err = lock.Acquire(filepath.Dir(dbLocation.Local), wait, timeout, func(pid int) {
+		util.Display(os.Stderr, false, "rpkgm is locked by PID %d, waiting for it to finish...", pid)
+	})
+
+	switch {
+	case errors.Is(err, lock.ErrLocked):
+		util.Display(
+			os.Stderr,
+			true,
+			"%s, wait for it to finish or re-run with --wait (and optionally --timeout).",
+			err,
+		)
+		util.Exit(1)
+	case err != nil:
+		util.Display(os.Stderr, true, "rpkgm could not take its lock. Error: %s", err)
+		util.Exit(1)
+	}
+
+	// Commands exiting early (ex: on an error) release it too
+	util.OnExit(unlockState)
+
+	if stateLock.StalePID != 0 {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm took over a stale lock left by PID %d, which is not running anymore.",
+			stateLock.StalePID,
+		)
+	}
+}
+
+// unlockState releases the lock taken by lockState, if it's still held.
+func unlockState() {
+	if stateLock == nil {
+		return
+	}
+
+	err := stateLock.Release()
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not release its lock. Error: %s", err)
+	}
+
+	stateLock = nil
+}
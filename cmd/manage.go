@@ -30,6 +30,8 @@ var (
 	newDesc          string
 	markInstalled    bool
 	markUninstalled  bool
+	markExplicit     bool
+	markAsDeps       bool
 	installedVersion string
 	repoVersion      string
 	archiveURL       string
@@ -47,6 +49,10 @@ var manageCmd = &cobra.Command{
 		// Check if user is root.
 		util.CheckRoot("Please run rpkgm manage as root.")
 
+		// Make sure no other rpkgm changes the system at the same time
+		lockState()
+		defer unlockState()
+
 		// Literally everything here needs a package's name, error if there isn't one
 		if name == "" {
 			util.Display(
@@ -62,7 +68,7 @@ var manageCmd = &cobra.Command{
 					"rpkgm could not display the help message. Error: %s",
 					err,
 				)
-				os.Exit(1)
+				util.Exit(1)
 			}
 		}
 
@@ -74,7 +80,7 @@ var manageCmd = &cobra.Command{
 
 		// Decide what to do and do what is needed to do
 		manage.Decide(
-			repoDB,
+			dbLocation,
 			name,
 			newName,
 			newDesc,
@@ -86,6 +92,8 @@ var manageCmd = &cobra.Command{
 			remove,
 			markInstalled,
 			markUninstalled,
+			markExplicit,
+			markAsDeps,
 		)
 	},
 }
@@ -96,7 +104,8 @@ func init() { //nolint:gochecknoinits
 	rootCmd.AddCommand(manageCmd)
 
 	// Flag for the name of a package
-	manageCmd.Flags().StringVarP(&name, "name", "n", "", "Name of the package to manage.")
+	manageCmd.Flags().
+		StringVarP(&name, "name", "n", "", "Name of the package to manage, optionally qualified by its repository (ex: extra/foo).")
 
 	// Flag for the new name of a package to be renamed
 	manageCmd.Flags().StringVar(&newName, "ren", "", "Rename a given package.")
@@ -116,6 +125,17 @@ func init() { //nolint:gochecknoinits
 	// Mark installed and uninstalled as incompatible together
 	manageCmd.MarkFlagsMutuallyExclusive("installed", "uninstalled")
 
+	// Flag to mark a package as explicitly installed
+	manageCmd.Flags().
+		BoolVar(&markExplicit, "explicit", false, "Mark a given package as explicitly installed (it will never be autoremoved).")
+
+	// Flag to mark a package as installed as a dependency
+	manageCmd.Flags().
+		BoolVar(&markAsDeps, "asdeps", false, "Mark a given package as installed as a dependency (it can be autoremoved).")
+
+	// Mark explicit and asdeps as incompatible together
+	manageCmd.MarkFlagsMutuallyExclusive("explicit", "asdeps")
+
 	// Flag to change a package's installed version in the db
 	manageCmd.Flags().
 		StringVar(&installedVersion, "iv", "", "Change a given package's installed version in the database.")
@@ -133,12 +153,12 @@ func init() { //nolint:gochecknoinits
 
 	// Flag to change a package's dependencies
 	manageCmd.Flags().
-		StringSliceVar(&dependencies, "deps", nil, "List of dependencires separated by commas for a given package.")
+		StringSliceVar(&dependencies, "deps", nil, "List of dependencires separated by commas for a given package, optionally with a version constraint (ex: zlib>=1.2.13).")
 
 	// Flag to remove a package from the repo
 	manageCmd.Flags().BoolVar(&remove, "rm", false, "Remove a given package from the repository.")
 
-	// Optional flag to specify repo database location
+	// Optional flag to only use a given repo database instead of the configured repositories
 	manageCmd.Flags().
-		StringVarP(&repoDB, "repo", "r", "var/rpkgm/main/main.db", "Specify repo Database location.")
+		StringVarP(&repoDB, "repo", "r", "", "Only use the repo database at the given location instead of the configured repositories.")
 }
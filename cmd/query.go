@@ -0,0 +1,62 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"github.com/redds-be/rpkgm/internal/query"
+	"github.com/spf13/cobra"
+)
+
+var (
+	owns          string
+	files         string
+	orphansOnDisk string
+)
+
+// queryCmd represents the query command.
+var queryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "Query the files installed by packages.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Decide what to do and do what is needed to do
+		query.Decide(dbLocation, cfg.Root, owns, files, orphansOnDisk)
+	},
+}
+
+// init initializes the command-line arguments for cobra.
+func init() { //nolint:gochecknoinits
+	// Link to root (root = 'rpkgm', query = 'rpkgm query')
+	rootCmd.AddCommand(queryCmd)
+
+	// Flag to find the package owning a path
+	queryCmd.Flags().StringVar(&owns, "owns", "", "Show the package that installed a given path.")
+
+	// Flag to list the files of a package
+	queryCmd.Flags().StringVar(&files, "files", "", "List the files installed by a given package.")
+
+	// Flag to list the files no package owns under a directory
+	queryCmd.Flags().
+		StringVar(&orphansOnDisk, "orphans-on-disk", "", "List the files under a given directory that no package installed.")
+
+	// Only one query at a time
+	queryCmd.MarkFlagsMutuallyExclusive("owns", "files", "orphans-on-disk")
+	queryCmd.MarkFlagsOneRequired("owns", "files", "orphans-on-disk")
+
+	// Optional flag to only use a given repo database instead of the configured repositories
+	queryCmd.Flags().
+		StringVarP(&repoDB, "repo", "r", "", "Only use the repo database at the given location instead of the configured repositories.")
+}
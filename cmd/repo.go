@@ -0,0 +1,101 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/redds-be/rpkgm/internal/repo"
+	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/spf13/cobra"
+)
+
+var outDir string
+
+// repoCmd represents the repo command.
+var repoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "Author a repository.",
+}
+
+// repoBuildCmd represents the repo build command.
+var repoBuildCmd = &cobra.Command{
+	Use:   "build <dir>",
+	Short: "Generate repo.json and the build files bundle of a repository.",
+	Long: `Generate repo.json and the <name>.tar.gz bundle of the build files, as rpkgm sync downloads them, from a directory
+holding the build files directory of every package. Each of them holds a Makefile and a ` + repo.MetadataFile + ` file:
+
+  {"description": "...", "version": "1.0", "archiveUrl": "https://...", "dependencies": "zlib>=1.2.13"}
+
+The archive is downloaded to compute its sha512 hash, unless "archiveFile" points to a local copy of it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// The repository is named after its directory by default
+		name := repoName
+		if name == "" {
+			absDir, _ := filepath.Abs(args[0])
+			name = filepath.Base(absDir)
+		}
+
+		// Decide what to do and do what is needed to do
+		repo.Build(args[0], name, outDir, privateKey)
+	},
+}
+
+// repoLintCmd represents the repo lint command.
+var repoLintCmd = &cobra.Command{
+	Use:   "lint <file>",
+	Short: "Check a repo.json file, every problem is reported with its line and its JSON path.",
+	Long: `Check a repo.json file, every problem is reported with its line and its JSON path.
+rpkgm add --import and rpkgm sync check the files they use the same way and refuse them if they have problems.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		problems, err := repo.Lint(args[0])
+		if err != nil {
+			util.Display(os.Stderr, false, "rpkgm could not read %s. Error: %s", args[0], err)
+			util.Exit(1)
+		}
+
+		for _, prob := range problems {
+			util.Display(os.Stdout, false, "%s", prob)
+		}
+
+		if len(problems) > 0 {
+			util.Display(os.Stdout, false, "%d problem(s) found.", len(problems))
+			util.Exit(1)
+		}
+
+		util.Display(os.Stdout, false, "No problem found in %s.", args[0])
+	},
+}
+
+// init initializes the command-line arguments for cobra.
+func init() { //nolint:gochecknoinits
+	// Link to root (root = 'rpkgm', repo = 'rpkgm repo', build = 'rpkgm repo build', lint = 'rpkgm repo lint')
+	rootCmd.AddCommand(repoCmd)
+	repoCmd.AddCommand(repoBuildCmd, repoLintCmd)
+
+	// Optional flag for the repository's name
+	repoBuildCmd.Flags().StringVarP(&repoName, "name", "n", "", "Name of the repository (defaults to the name of the directory).")
+
+	// Optional flag for where to write the files
+	repoBuildCmd.Flags().StringVarP(&outDir, "output", "o", ".", "Directory to write repo.json and the bundle into.")
+
+	// Optional flag to sign the files
+	repoBuildCmd.Flags().StringVarP(&privateKey, "key", "k", "", "Private key file to sign the files with (see rpkgm key generate).")
+}
@@ -18,7 +18,9 @@ package cmd
 
 import (
 	"os"
+	"time"
 
+	"github.com/redds-be/rpkgm/internal/database"
 	"github.com/redds-be/rpkgm/internal/pkg"
 	"github.com/redds-be/rpkgm/internal/util"
 	"github.com/spf13/cobra"
@@ -27,12 +29,20 @@ import (
 var (
 	toInstall   []string
 	toUninstall []string
+	overwrite   []string
 	verbose     bool
 	keep        bool
 	force       bool
 	yes         bool
 	resolve     bool
+	cascade     bool
+	nodeps      bool
+	resume      bool
+	rollback    bool
+	wait        bool
+	timeout     time.Duration
 	repoDB      string
+	dbLocation  database.Location
 )
 
 // rootCmd represents the base command when called without any subcommands.
@@ -43,11 +53,26 @@ var rootCmd = &cobra.Command{
 This program comes with ABSOLUTELY NO WARRANTY; for details type 'rpkgm show -w'.
 This is free software, and you are welcome to redistribute it
 under certain conditions; see <https://www.gnu.org/licenses/gpl-3.0.html>.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Every command uses the configuration, overridden by its flags
+		loadConfig(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(toInstall) > 0 {
-			pkg.Decide(true, force, verbose, keep, yes, resolve, toInstall, repoDB)
+		if resume || rollback || len(toInstall) > 0 || len(toUninstall) > 0 {
+			// Check if the user is root
+			util.CheckRoot("Please run rpkgm as root.")
+
+			// Make sure no other rpkgm changes the system at the same time
+			lockState()
+			defer unlockState()
+		}
+
+		if resume || rollback {
+			pkg.Recover(rollback, verbose, keep, force, overwrite, dbLocation)
+		} else if len(toInstall) > 0 {
+			pkg.Decide(true, force, verbose, keep, yes, cascade, nodeps, toInstall, overwrite, dbLocation)
 		} else if len(toUninstall) > 0 {
-			pkg.Decide(false, force, verbose, keep, yes, resolve, toUninstall, repoDB)
+			pkg.Decide(false, force, verbose, keep, yes, cascade, nodeps, toUninstall, nil, dbLocation)
 		} else {
 			err := cmd.Help()
 			if err != nil {
@@ -68,6 +93,26 @@ func Execute() {
 
 // init initializes the command-line arguments for cobra.
 func init() { //nolint:gochecknoinits
+	// Flag to install into another root than / (ex: a chroot or an image being built)
+	rootCmd.PersistentFlags().
+		String("root", "/", "Install packages into the given root, relative paths (database, cache, log) are relative to it.")
+
+	// Flag to use another database than the configured one
+	rootCmd.PersistentFlags().
+		String("dbpath", "", "Specify the location of the local database, which records what is installed.")
+
+	// Flag to use another cache directory than the configured one
+	rootCmd.PersistentFlags().
+		String("cachedir", "", "Specify the directory the repositories' files are downloaded into.")
+
+	// Flag to wait for the lock instead of failing when another rpkgm is running, for every command
+	rootCmd.PersistentFlags().
+		BoolVar(&wait, "wait", false, "Wait for another running rpkgm to finish instead of failing.")
+
+	// Flag to stop waiting for the lock after a while
+	rootCmd.PersistentFlags().
+		DurationVar(&timeout, "timeout", 0, "Give up waiting for the lock after the given duration (ex: 30s, 5m), 0 waits forever.")
+
 	// Flag for a list of packages to install
 	rootCmd.Flags().
 		StringSliceVarP(&toInstall, "install", "i", nil, "Package(s) to install. For multiple packages, separate them with commas.")
@@ -94,11 +139,46 @@ func init() { //nolint:gochecknoinits
 	// Flag to indicate there is no need for confirmation
 	rootCmd.Flags().BoolVarP(&yes, "yes", "y", false, "Do not ask before installing/uninstalling.")
 
-	// Flag to indicate whether we try to resolve dependencies
+	// Flag to indicate whether we try to resolve dependencies, kept so existing scripts don't break
+	rootCmd.Flags().
+		BoolVar(&resolve, "resolve", false, "Resolve dependencies.")
+
+	// Dependencies are always resolved now
+	err := rootCmd.Flags().MarkDeprecated("resolve", "dependencies are always resolved.")
+	if err != nil {
+		util.Display(os.Stderr, false, "rpkgm could not deprecate the --resolve flag. Error: %s", err)
+	}
+
+	// Flag to also uninstall the packages depending on the packages to uninstall
+	rootCmd.Flags().
+		BoolVar(&cascade, "cascade", false, "Also uninstall the installed packages that depend on the package(s) to uninstall.")
+
+	// Flag to ignore dependencies altogether
+	rootCmd.Flags().
+		BoolVar(&nodeps, "nodeps", false, "Do not resolve dependencies when installing nor check reverse dependencies when uninstalling.")
+
+	// Flag to allow overwriting files owned by other packages or untracked files
 	rootCmd.Flags().
-		BoolVar(&resolve, "resolve", false, "Resolve dependencies (experimental feature, disabled by default).")
+		StringSliceVar(&overwrite, "overwrite", nil, "Overwrite conflicting files matching the given glob(s) (ex: '/usr/lib/*'), separate them with commas.")
+
+	// Cascading only makes sense when uninstalling and when dependencies aren't ignored
+	rootCmd.MarkFlagsMutuallyExclusive("install", "cascade")
+	rootCmd.MarkFlagsMutuallyExclusive("cascade", "nodeps")
+
+	// Flag to finish an interrupted transaction
+	rootCmd.Flags().
+		BoolVar(&resume, "continue", false, "Finish the transaction that was interrupted.")
+
+	// Flag to undo an interrupted transaction
+	rootCmd.Flags().
+		BoolVar(&rollback, "rollback", false, "Roll back the transaction that was interrupted.")
+
+	// Recovering a transaction is an operation of its own
+	rootCmd.MarkFlagsMutuallyExclusive("continue", "rollback")
+	rootCmd.MarkFlagsMutuallyExclusive("continue", "install", "uninstall")
+	rootCmd.MarkFlagsMutuallyExclusive("rollback", "install", "uninstall")
 
-	// Optional flag to specify repo database location
+	// Optional flag to only use a given repo database instead of the configured repositories
 	rootCmd.Flags().
-		StringVarP(&repoDB, "repo", "r", "var/rpkgm/main/main.db", "Specify repo Database location.")
+		StringVarP(&repoDB, "repo", "r", "", "Only use the repo database at the given location instead of the configured repositories.")
 }
@@ -57,7 +57,7 @@ var showCmd = &cobra.Command{
 		// show rpkgm's warranty notice
 		if showNotice {
 			util.Display(os.Stdout, false, "%s", notice)
-			os.Exit(0)
+			util.Exit(0)
 		}
 
 		if showLicense || showInfo {
@@ -76,12 +76,12 @@ var showCmd = &cobra.Command{
 						err,
 					)
 				}
-				os.Exit(1)
+				util.Exit(1)
 			}
 		}
 
 		// Decide what to do and do what is needed to do
-		show.Decide(repoDB, name, showLicense, showInfo, showAll)
+		show.Decide(dbLocation, cfg.Resolve(cfg.CacheDir), name, showLicense, showInfo, showAll)
 	},
 }
 
@@ -107,7 +107,7 @@ func init() { //nolint:gochecknoinits
 	showCmd.Flags().
 		BoolVarP(&showAll, "all", "a", false, "Show every package's general information.")
 
-	// Optional flag to specify repo database location
+	// Optional flag to only use a given repo database instead of the configured repositories
 	showCmd.Flags().
-		StringVarP(&repoDB, "repo", "r", "var/rpkgm/main/main.db", "Specify repo Database location.")
+		StringVarP(&repoDB, "repo", "r", "", "Only use the repo database at the given location instead of the configured repositories.")
 }
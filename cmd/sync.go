@@ -17,6 +17,9 @@
 package cmd
 
 import (
+	"os"
+
+	"github.com/redds-be/rpkgm/internal/database"
 	"github.com/redds-be/rpkgm/internal/sync"
 	"github.com/redds-be/rpkgm/internal/util"
 	"github.com/spf13/cobra"
@@ -25,21 +28,80 @@ import (
 var (
 	repoName string
 	remote   string
+	repoURL  string
+	branch   string
+	insecure bool
 )
 
 // syncCmd represents the sync command.
 var syncCmd = &cobra.Command{
 	Use:   "sync",
-	Short: "Sync the main or a specified repo.",
+	Short: "Sync every configured repo or a specified one.",
 	Run: func(cmd *cobra.Command, args []string) {
 		// Check if user is root.
 		util.CheckRoot("Please run rpkgm sync as root.")
 
+		// Make sure no other rpkgm changes the system at the same time
+		lockState()
+		defer unlockState()
+
+		// What the repositories' databases say is installed is kept before they are opened
+		keepInstalledState()
+
 		// Decide what to do and do what is needed to do
-		sync.Decide(repoDB, importFile, remote, repoName)
+		sync.Decide(dbLocation, syncedRepos(cmd), importFile, insecure, force)
 	},
 }
 
+// syncedRepos returns the repos to sync: the one given with --name, the highest priority one when a file is imported,
+// else every configured repo.
+func syncedRepos(cmd *cobra.Command) []database.RepoLocation {
+	repos := dbLocation.Repos
+
+	switch {
+	case repoName != "":
+		location := database.RepoLocation{Name: repoName, Path: cfg.Resolve(cfg.RepoDB(repoName))}
+		if repo, isDeclared := cfg.Repo(repoName); isDeclared {
+			location.Source = repo.Source()
+		}
+
+		// The given database is the one of the named repo
+		if cmd.Flags().Changed("repo") {
+			location.Path = primaryRepo().Path
+		}
+
+		repos = []database.RepoLocation{location}
+	case importFile != "":
+		repos = []database.RepoLocation{primaryRepo()}
+	}
+
+	// A remote, URL or branch given on the command line is the one of a single repo
+	if cmd.Flags().Changed("remote") || cmd.Flags().Changed("url") || cmd.Flags().Changed("branch") {
+		if len(repos) != 1 {
+			util.Display(os.Stderr, false, "--remote, --url and --branch need the --name of the repo to sync.")
+			util.Exit(1)
+		}
+
+		// What isn't given on the command line is taken from the repo's section
+		repo, _ := cfg.Repo(repos[0].Name)
+		if cmd.Flags().Changed("remote") {
+			repo.Remote, repo.URL = remote, ""
+		}
+
+		if cmd.Flags().Changed("url") {
+			repo.URL = repoURL
+		}
+
+		if cmd.Flags().Changed("branch") {
+			repo.Branch = branch
+		}
+
+		repos[0].Source = repo.Source()
+	}
+
+	return repos
+}
+
 // init initializes the command-line arguments for cobra.
 func init() { //nolint:gochecknoinits
 	// Link to root (root = 'rpkgm', sync = 'rpkgm sync')
@@ -51,16 +113,32 @@ func init() { //nolint:gochecknoinits
 
 	// Optional flag to specify repo database location
 	syncCmd.Flags().
-		StringVar(&repoDB, "repo", "var/rpkgm/main/main.db", "Specify repo Database location.")
+		StringVar(&repoDB, "repo", "", "Specify repo Database location (defaults to <cache_dir>/<name>/<name>.db).")
 
-	// Optional flag to specify a remote GitHub repo (only supports github for now)
+	// Optional flag to specify a remote forge repo
 	syncCmd.Flags().
-		StringVar(&remote, "remote", "github.com/redds-be/rpkgm-main",
-			"Specify a remote, only works with GitHub for now. (ex: github.com/<user>/<repo> without .git)")
+		StringVar(&remote, "remote", "",
+			"Specify a forge's repo instead of the one of the repo's section, laid out like GitHub's. (ex: github.com/<user>/<repo> without .git)")
 
-	// Flag for the repo's name
-	syncCmd.Flags().StringVarP(&repoName, "name", "n", "main", "Name of the repository.")
+	// Optional flag to specify any URL
+	syncCmd.Flags().
+		StringVar(&repoURL, "url", "", "Specify the http(s):// or file:// URL of the repo instead of the one of the repo's section.")
+
+	// A repo is either a forge's or at a given URL
+	syncCmd.MarkFlagsMutuallyExclusive("remote", "url")
+
+	// Optional flag to specify the branch
+	syncCmd.Flags().
+		StringVar(&branch, "branch", "", "Specify the branch or tag of the repo to sync (defaults to main).")
+
+	// Flag to sync repositories that don't sign their files, or whose key isn't trusted
+	syncCmd.Flags().
+		BoolVar(&insecure, "insecure", false, "Do not check the signatures of the repositories' files (dangerous).")
 
-	// If you use --remote, you should use --name
-	syncCmd.MarkFlagsRequiredTogether("remote", "name", "repo")
+	// Flag to sync repositories even if their files didn't change since their last sync
+	syncCmd.Flags().
+		BoolVarP(&force, "force", "f", false, "Download and apply the repositories' files even if they did not change since the last sync.")
+
+	// Flag for the repo's name
+	syncCmd.Flags().StringVarP(&repoName, "name", "n", "", "Name of the repository to sync, every configured repository is synced if not given.")
 }
@@ -25,6 +25,7 @@ import (
 var (
 	updateList []string
 	all        bool
+	downgrade  bool
 )
 
 // updateCmd represents the update command.
@@ -39,14 +40,22 @@ var updateCmd = &cobra.Command{
 			// Check if the user is root
 			util.CheckRoot("Please run rpkgm update as root.")
 
-			update.Decide(repoDB, updateList, all, false, verbose, yes, keep)
+			// Make sure no other rpkgm changes the system at the same time
+			lockState()
+			defer unlockState()
+
+			update.Decide(dbLocation, updateList, all, false, verbose, yes, keep, downgrade)
 		} else if all {
 			// Check if the user is root
 			util.CheckRoot("Please run rpkgm update as root.")
 
-			update.Decide(repoDB, nil, true, false, verbose, yes, keep)
+			// Make sure no other rpkgm changes the system at the same time
+			lockState()
+			defer unlockState()
+
+			update.Decide(dbLocation, nil, true, false, verbose, yes, keep, downgrade)
 		} else {
-			update.Decide(repoDB, nil, false, true, false, false, false)
+			update.Decide(dbLocation, nil, false, true, false, false, false, false)
 		}
 	},
 }
@@ -65,6 +74,10 @@ func init() { //nolint:gochecknoinits
 	// List of packages and every packages are incompatible
 	updateCmd.MarkFlagsMutuallyExclusive("update", "all")
 
+	// Flag to allow downgrading packages whose repo version is older than the installed one
+	updateCmd.Flags().
+		BoolVar(&downgrade, "downgrade", false, "Allow downgrading packages whose repo's version is older than the installed one.")
+
 	// Flag for verbosity
 	updateCmd.Flags().
 		BoolVarP(&verbose, "verbose", "v", false, "Make rpkgm verbose during operation.")
@@ -76,7 +89,7 @@ func init() { //nolint:gochecknoinits
 	updateCmd.Flags().
 		BoolVarP(&keep, "keep", "k", false, "Keep package(s) source directories after update (/usr/src/rpkgm/<pkgName>)")
 
-	// Optional flag to specify repo database location
+	// Optional flag to only use a given repo database instead of the configured repositories
 	updateCmd.Flags().
-		StringVarP(&repoDB, "repo", "r", "var/rpkgm/main/main.db", "Specify repo Database location.")
+		StringVarP(&repoDB, "repo", "r", "", "Only use the repo database at the given location instead of the configured repositories.")
 }
@@ -0,0 +1,49 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"github.com/redds-be/rpkgm/internal/verify"
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify command.
+var verifyCmd = &cobra.Command{
+	Use:   "verify [package]...",
+	Short: "Check the installed files of packages against what was recorded when they were installed.",
+	Long: `Check the installed files of packages against what was recorded when they were installed.
+Every installed package is verified if none is given. Missing, modified and permission-changed files are reported,
+and rpkgm exits with an error if any problem is found.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Decide what to do and do what is needed to do
+		verify.Decide(dbLocation, cfg.Root, args, verbose)
+	},
+}
+
+// init initializes the command-line arguments for cobra.
+func init() { //nolint:gochecknoinits
+	// Link to root (root = 'rpkgm', verify = 'rpkgm verify')
+	rootCmd.AddCommand(verifyCmd)
+
+	// Flag for verbosity
+	verifyCmd.Flags().
+		BoolVarP(&verbose, "verbose", "v", false, "Make rpkgm verbose during operation.")
+
+	// Optional flag to only use a given repo database instead of the configured repositories
+	verifyCmd.Flags().
+		StringVarP(&repoDB, "repo", "r", "", "Only use the repo database at the given location instead of the configured repositories.")
+}
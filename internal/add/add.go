@@ -1,23 +1,69 @@
 package add
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/repo"
 	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/redds-be/rpkgm/internal/version"
 )
 
-// importPkgs imports packages from a file to a repo.
+// Errors returned when a package to import lacks what is needed to install it.
+var (
+	ErrNoArchiveURL = errors.New("there is no archive URL")
+	ErrNoHash       = errors.New("there is no hash for the archive")
+)
+
+// CheckPkg makes sure a package to import can be installed and gives their default value to its optional fields.
+func CheckPkg(pkg *database.Package) error {
+	if pkg.ArchiveURL == "" {
+		return ErrNoArchiveURL
+	}
+
+	if pkg.Sha512 == "" {
+		return ErrNoHash
+	}
+
+	// Make sure the dependencies list is valid (ex: zlib>=1.2.13 openssl<3 foo=1.4.*)
+	if _, err := version.ParseConstraints(pkg.Dependencies); err != nil {
+		return fmt.Errorf("the dependencies list is invalid: %w", err)
+	}
+
+	// If there isn't a description, give one by default
+	if pkg.Description == "" {
+		pkg.Description = "[No description provided for this package.]"
+	}
+
+	// If there isn't a build files dir, give one by default: the package's directory in the one of the repo's files
+	// (see database.PkgInfo.BuildFilesPath)
+	if pkg.BuildFilesDir == "" {
+		pkg.BuildFilesDir = pkg.Name
+	}
+
+	// Remove any trailing /
+	pkg.BuildFilesDir = strings.TrimSuffix(pkg.BuildFilesDir, "/")
+
+	return nil
+}
+
+// ImportPkgs imports packages from a file to a repo, the packages the repo already has are updated.
 func ImportPkgs(importFile string, dbAdapter *database.Adapter) { //nolint:funlen,cyclop
+	// Refuse a file with problems, before anything is changed
+	repo.CheckFile(importFile)
+
 	// Open the file to import
 	jsonPkgFile, err := os.Open(importFile)
 	if err != nil {
 		util.Display(os.Stderr, true, "rpkgm couldn't open the json file. Error: %s", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Read the file's content
@@ -28,7 +74,7 @@ func ImportPkgs(importFile string, dbAdapter *database.Adapter) { //nolint:funle
 			"rpkgm couldn't read the json file's content. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Initialize the Packages struct
@@ -38,128 +84,125 @@ func ImportPkgs(importFile string, dbAdapter *database.Adapter) { //nolint:funle
 	err = json.Unmarshal(contentInbytes, &pkgs)
 	if err != nil {
 		util.Display(os.Stderr, true, "rpkgm couldn't read the json file. Error: %s", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
-	// for every package in the json file, add it to the repo
-	for index := 0; index < len(pkgs.Packages); index++ {
-		// If there isn't an archive url, skip and print an error
-		if pkgs.Packages[index].ArchiveURL == "" {
-			util.Display(
-				os.Stderr,
-				true,
-				"rpkgm could not find an archive URL for %s, skipping...",
-				pkgs.Packages[index].Name,
-			)
-
-			continue
-		}
-
-		// If there isn't a hash, skip and print an error
-		if pkgs.Packages[index].Sha512 == "" {
-			util.Display(
-				os.Stderr,
-				true,
-				"rpkgm could not find a hash for the archive for %s, skipping...",
-				pkgs.Packages[index].Name,
-			)
-
-			continue
-		}
-
-		// If there isn't a description, give one by default
-		if pkgs.Packages[index].Description == "" {
-			pkgs.Packages[index].Description = "[No description provided for this package.]"
-		}
-
-		// If there isn't a build files dir, give one by default
-		if pkgs.Packages[index].BuildFilesDir == "" {
-			pkgs.Packages[index].BuildFilesDir = fmt.Sprintf(
-				"var/rpkgm/main/%s",
+	// Apply every package in a single transaction, so that the repo is never left half updated
+	err = dbAdapter.WithTx(func(txAdapter *database.Adapter) error {
+		// for every package in the json file, add it to the repo
+		for index := 0; index < len(pkgs.Packages); index++ {
+			// If the package can't be installed, skip and print an error
+			err := CheckPkg(&pkgs.Packages[index])
+			if err != nil {
+				util.Display(
+					os.Stderr,
+					true,
+					"rpkgm could not import %s, skipping... Error: %s",
+					pkgs.Packages[index].Name,
+					err,
+				)
+
+				continue
+			}
+
+			// Update the package if the repo already has it, add it otherwise
+			isInRepo, err := txAdapter.IsPkgInRepo(pkgs.Packages[index].Name)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("rpkgm was unable to add %s to the repo. Error: %w", pkgs.Packages[index].Name, err)
+			}
+
+			importPkg := txAdapter.AddToRepo
+			if isInRepo {
+				importPkg = txAdapter.SyncRepo
+			}
+
+			err = importPkg(
 				pkgs.Packages[index].Name,
+				pkgs.Packages[index].Description,
+				pkgs.Packages[index].Version,
+				pkgs.Packages[index].BuildFilesDir,
+				pkgs.Packages[index].ArchiveURL,
+				pkgs.Packages[index].Sha512,
+				pkgs.Packages[index].Dependencies,
 			)
+			if err != nil {
+				return fmt.Errorf("rpkgm was unable to add %s to the repo. Error: %w", pkgs.Packages[index].Name, err)
+			}
 		}
 
-		// Remove any trailing /
-		pkgs.Packages[index].BuildFilesDir = strings.TrimSuffix(
-			pkgs.Packages[index].BuildFilesDir,
-			"/",
-		)
-
-		// Add the package to the repo
-		err = dbAdapter.AddToRepo(
-			pkgs.Packages[index].Name,
-			pkgs.Packages[index].Description,
-			pkgs.Packages[index].Version,
-			pkgs.Packages[index].BuildFilesDir,
-			pkgs.Packages[index].ArchiveURL,
-			pkgs.Packages[index].Sha512,
-			pkgs.Packages[index].Dependencies,
-		)
-		if err != nil {
-			util.Display(
-				os.Stderr, true,
-				"rpkgm was unable to add %s to the repo. Error: %s",
-				pkgs.Packages[index].Name,
-				err,
-			)
-		}
+		return nil
+	})
+	if err != nil {
+		util.Display(os.Stderr, true, "%s, nothing was imported.", err)
+		util.Exit(1)
 	}
 
 	// Close the json file
 	err = jsonPkgFile.Close()
 	if err != nil {
 		util.Display(os.Stderr, true, "rpgkm couln't close the json file. Error: %s", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
 // addPkg adds a packages with its general information to a repo.
 func addPkg(
-	name, description, version, buildFilesDir, archiveURL, hash, deps string,
+	name, description, pkgVersion, buildFilesDir, archiveURL, hash, deps string,
 	dbAdapter *database.Adapter,
 ) {
-	// Default value for buildFilesDir (doing it here instead of Flags() because I need 'name')
-	if buildFilesDir == "" {
-		buildFilesDir = fmt.Sprintf("var/rpkgm/main/%s", name)
+	pkg := database.Package{
+		Name:          name,
+		Description:   description,
+		Version:       pkgVersion,
+		BuildFilesDir: buildFilesDir,
+		ArchiveURL:    archiveURL,
+		Sha512:        hash,
+		Dependencies:  deps,
 	}
 
-	// Remove any trailing /
-	buildFilesDir = strings.TrimSuffix(buildFilesDir, "/")
+	// Check the package and give their default value to the optional fields, like an imported one
+	err := CheckPkg(&pkg)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not add the package %s to the repo. Error: %s", name, err)
+		util.Exit(1)
+	}
 
 	// Add the package to the main repo
-	err := dbAdapter.AddToRepo(name, description, version, buildFilesDir, archiveURL, hash, deps)
+	err = dbAdapter.AddToRepo(
+		pkg.Name,
+		pkg.Description,
+		pkg.Version,
+		pkg.BuildFilesDir,
+		pkg.ArchiveURL,
+		pkg.Sha512,
+		pkg.Dependencies,
+	)
 	if err != nil {
 		util.Display(
 			os.Stderr, true,
 			"rpkgm could not add the package %s to the repo. Error: %s",
 			name, err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
 // Decide decides what to do based on the given strings.
 func Decide(
-	repoDB, name, description, version, buildFilesDir, archiveURL, hash, deps, importFile string,
+	repoDB, name, description, pkgVersion, buildFilesDir, archiveURL, hash, deps, importFile string,
 ) {
-	// Connect to the database
-	dbAdapter, err := database.NewAdapter("sqlite3", repoDB)
+	// Create the directory of the database if the repo was never synced
+	err := os.MkdirAll(filepath.Dir(repoDB), os.ModePerm)
 	if err != nil {
-		util.Display(os.Stderr, true, "rpkgm could not connect to the database. Error: %s", err)
-		os.Exit(1)
+		util.Display(os.Stderr, true, "rpkgm could not create the directory of the repo's database. Error: %s", err)
+		util.Exit(1)
 	}
 
-	// Create the packages table if it does not exist
-	err = dbAdapter.CreatePkgTable()
+	// Connect to the database
+	dbAdapter, err := database.NewAdapter("sqlite3", repoDB)
 	if err != nil {
-		util.Display(
-			os.Stderr,
-			true,
-			"rpkgm could not create the packages table in the repo. Error: %s",
-			err,
-		)
-		os.Exit(1)
+		util.Display(os.Stderr, true, "rpkgm could not connect to the database. Error: %s", err)
+		util.Exit(1)
 	}
 
 	// import a file to the repo
@@ -168,8 +211,8 @@ func Decide(
 	}
 
 	// add a package to the repo
-	if name != "" && version != "" {
-		addPkg(name, description, version, buildFilesDir, archiveURL, hash, deps, dbAdapter)
+	if name != "" && pkgVersion != "" {
+		addPkg(name, description, pkgVersion, buildFilesDir, archiveURL, hash, deps, dbAdapter)
 	}
 
 	// Close the database connection
@@ -180,6 +223,6 @@ func Decide(
 			"rpkgm could not close the connection to the database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
@@ -0,0 +1,64 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package autoremove
+
+import (
+	"os"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/pkg"
+	"github.com/redds-be/rpkgm/internal/solver"
+	"github.com/redds-be/rpkgm/internal/util"
+)
+
+// Decide finds the orphaned packages and uninstalls them.
+func Decide(dbLocation database.Location, verbose, keep, yes bool) {
+	// Connect to the database
+	store, err := database.Open(dbLocation)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not connect to the database. Error: %s", err)
+		util.Exit(1)
+	}
+
+	// Find the packages installed as dependencies that aren't needed anymore
+	orphans, err := solver.Orphans(store)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not find the orphaned packages. Error: %s", err)
+		util.Exit(1)
+	}
+
+	// Close the database connection, uninstalling opens its own
+	err = store.CloseDBConnection()
+	if err != nil {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not close the connection to the database. Error: %s",
+			err,
+		)
+		util.Exit(1)
+	}
+
+	if len(orphans) == 0 {
+		util.Display(os.Stdout, false, "No orphaned packages to uninstall.")
+
+		return
+	}
+
+	// Uninstall them through the usual flow, which asks for confirmation
+	pkg.Decide(false, false, verbose, keep, yes, false, false, orphans, nil, dbLocation)
+}
@@ -0,0 +1,460 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SystemFile is the system-wide configuration file, RPKGM_CONFIG can point to another one.
+const SystemFile = "/etc/rpkgm.conf"
+
+// envPrefix is the prefix of the environment variables overriding the settings (ex: RPKGM_DB_PATH).
+const envPrefix = "RPKGM_"
+
+// ErrInvalidConfig is returned when a configuration file or variable can't be parsed.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Layouts of a repository's files. {url} stands for the URL of the repository, {branch} for its branch (or tag) and
+// {file} for the name of a file (ex: repo.json).
+const (
+	DefaultLayout = "{url}/{file}"
+	ForgeLayout   = "{url}/raw/{branch}/{file}"
+)
+
+// DefaultBranch is the branch of a repository when none is set.
+const DefaultBranch = "main"
+
+// Repo defines a repository declared in a [repo NAME] section. Remote is a forge's repository (ex:
+// github.com/<user>/<repo>), URL any http(s):// or file:// base URL, which takes precedence over it. Keys are the
+// names of the keyring's keys its files can be signed with.
+type Repo struct {
+	Name     string
+	Remote   string
+	URL      string
+	Layout   string
+	Branch   string
+	Priority int
+	Keys     []string
+}
+
+// Config defines the settings of rpkgm.
+type Config struct {
+	Root            string
+	DBPath          string
+	CacheDir        string
+	BuildDir        string
+	SrcDir          string
+	LogFile         string
+	Yes             bool
+	Keep            bool
+	Verbose         bool
+	MakeArgs        string
+	DownloadTimeout time.Duration
+	DownloadRetries int
+	KeyringDir      string
+	Repos           []Repo
+	// sources holds where each setting was last set from
+	sources map[string]string
+}
+
+// setting defines a key of the global section along with the field it sets and, optionally, what its value has to be.
+type setting struct {
+	key   string
+	field func(cfg *Config) any
+	check func(value string) error
+}
+
+// settings lists every key of the global section, in the order they are shown.
+var settings = []setting{
+	{key: "root", field: func(cfg *Config) any { return &cfg.Root }},
+	{key: "db_path", field: func(cfg *Config) any { return &cfg.DBPath }},
+	{key: "cache_dir", field: func(cfg *Config) any { return &cfg.CacheDir }},
+	{key: "build_dir", field: func(cfg *Config) any { return &cfg.BuildDir }},
+	{key: "src_dir", field: func(cfg *Config) any { return &cfg.SrcDir }},
+	{key: "log_file", field: func(cfg *Config) any { return &cfg.LogFile }},
+	{key: "yes", field: func(cfg *Config) any { return &cfg.Yes }},
+	{key: "keep", field: func(cfg *Config) any { return &cfg.Keep }},
+	{key: "verbose", field: func(cfg *Config) any { return &cfg.Verbose }},
+	{key: "make_args", field: func(cfg *Config) any { return &cfg.MakeArgs }},
+	{key: "download_timeout", field: func(cfg *Config) any { return &cfg.DownloadTimeout }},
+	{key: "download_retries", field: func(cfg *Config) any { return &cfg.DownloadRetries }},
+	{key: "keyring_dir", field: func(cfg *Config) any { return &cfg.KeyringDir }, check: isAbsolute},
+}
+
+// isAbsolute makes sure a path is absolute. The keyring is what the repositories' files are trusted against, it can't
+// depend on the working directory nor on the root.
+func isAbsolute(value string) error {
+	if !filepath.IsAbs(value) {
+		return fmt.Errorf("%q is not an absolute path", value) //nolint:goerr113
+	}
+
+	return nil
+}
+
+// parse parses a value and stores it in the setting's field.
+func (set setting) parse(cfg *Config, value string) error {
+	if set.check != nil {
+		if err := set.check(value); err != nil {
+			return err
+		}
+	}
+
+	switch field := set.field(cfg).(type) {
+	case *string:
+		*field = value
+	case *bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid boolean (true or false)", value) //nolint:goerr113
+		}
+		*field = parsed
+	case *int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("%q is not a valid positive number", value) //nolint:goerr113
+		}
+		*field = parsed
+	case *time.Duration:
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("%q is not a valid duration (ex: 30s, 5m)", value) //nolint:goerr113
+		}
+		*field = parsed
+	}
+
+	return nil
+}
+
+// format returns the value of the setting's field as it would be written in a configuration file.
+func (set setting) format(cfg *Config) string {
+	switch field := set.field(cfg).(type) {
+	case *string:
+		return *field
+	case *bool:
+		return strconv.FormatBool(*field)
+	case *int:
+		return strconv.Itoa(*field)
+	case *time.Duration:
+		return field.String()
+	default:
+		return ""
+	}
+}
+
+// Default returns the settings used when nothing else sets them.
+func Default() *Config {
+	cfg := &Config{
+		Root:       "/",
+		DBPath:     "var/rpkgm/local.db",
+		CacheDir:   "var/rpkgm",
+		BuildDir:   "/tmp/rpkgm",
+		SrcDir:     "/tmp/usr/src/rpkgm",
+		LogFile:    "var/log/rpkgm.log",
+		KeyringDir: "/etc/rpkgm/keys",
+		Repos:      []Repo{{Name: "main", Remote: "github.com/redds-be/rpkgm-main"}},
+		sources:    make(map[string]string),
+	}
+
+	for _, set := range settings {
+		cfg.sources[set.key] = "default"
+	}
+	cfg.sources["repo main"] = "default"
+
+	return cfg
+}
+
+// ResolvePath returns a path as seen from a root, absolute paths are kept as they are. Relative paths are always
+// relative to the root, whatever the working directory.
+func ResolvePath(root, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(root, path)
+}
+
+// Resolve returns a path as seen from the configured root.
+func (cfg *Config) Resolve(path string) string {
+	return ResolvePath(cfg.Root, path)
+}
+
+// Files returns the configuration files that are read, in order, the later ones taking precedence.
+func Files() []string {
+	files := []string{SystemFile}
+	if path := os.Getenv(envPrefix + "CONFIG"); path != "" {
+		files[0] = path
+	}
+
+	if userDir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(userDir, "rpkgm", "rpkgm.conf"))
+	}
+
+	return files
+}
+
+// Load returns the settings merged from the defaults, the configuration files and the environment, in that order.
+// Missing configuration files are skipped.
+func Load() (*Config, error) {
+	cfg := Default()
+
+	for _, path := range Files() {
+		err := cfg.loadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	err := cfg.loadEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile reads a configuration file made of key = value lines, [repo NAME] sections and # comments.
+func (cfg *Config) loadFile(path string) error { //nolint:cyclop
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	var repo *Repo
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Start of a repository's section
+		if section, isSection := strings.CutPrefix(line, "["); isSection {
+			name, isRepo := strings.CutPrefix(strings.TrimSuffix(section, "]"), "repo ")
+			name = strings.TrimSpace(name)
+			if !strings.HasSuffix(section, "]") || !isRepo || name == "" {
+				return errors.Join(
+					fmt.Errorf("%w: %s:%d: sections must be [repo NAME]", ErrInvalidConfig, path, lineNumber),
+					file.Close(),
+				)
+			}
+
+			repo = cfg.repo(name)
+			cfg.sources["repo "+name] = path
+
+			continue
+		}
+
+		key, value, hasValue := strings.Cut(line, "=")
+		if !hasValue {
+			return errors.Join(
+				fmt.Errorf("%w: %s:%d: expected key = value", ErrInvalidConfig, path, lineNumber),
+				file.Close(),
+			)
+		}
+
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		if repo != nil {
+			err = repo.set(key, value)
+		} else {
+			err = cfg.set(key, value, path)
+		}
+
+		if err != nil {
+			return errors.Join(fmt.Errorf("%w: %s:%d: %w", ErrInvalidConfig, path, lineNumber, err), file.Close())
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return errors.Join(err, file.Close())
+	}
+
+	return file.Close()
+}
+
+// loadEnv reads the settings from the RPKGM_* environment variables (ex: RPKGM_BUILD_DIR for build_dir).
+func (cfg *Config) loadEnv() error {
+	for _, set := range settings {
+		name := envPrefix + strings.ToUpper(set.key)
+
+		value, isSet := os.LookupEnv(name)
+		if !isSet {
+			continue
+		}
+
+		err := cfg.set(set.key, value, "environment ("+name+")")
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, name, err)
+		}
+	}
+
+	return nil
+}
+
+// set sets a key of the global section and records where it was set from.
+func (cfg *Config) set(key, value, source string) error {
+	idx := slices.IndexFunc(settings, func(set setting) bool { return set.key == key })
+	if idx < 0 {
+		return fmt.Errorf("unknown key %q", key) //nolint:goerr113
+	}
+
+	err := settings[idx].parse(cfg, value)
+	if err != nil {
+		return err
+	}
+	cfg.sources[key] = source
+
+	return nil
+}
+
+// set sets a key of a repository's section.
+func (repo *Repo) set(key, value string) error {
+	switch key {
+	case "remote":
+		repo.Remote = value
+	case "url":
+		if !strings.HasPrefix(value, "https://") && !strings.HasPrefix(value, "http://") &&
+			!strings.HasPrefix(value, "file://") {
+			return fmt.Errorf("%q is not an http://, https:// nor file:// URL", value) //nolint:goerr113
+		}
+		repo.URL = value
+	case "layout":
+		if !strings.Contains(value, "{file}") {
+			return fmt.Errorf("the layout %q has no {file}", value) //nolint:goerr113
+		}
+		repo.Layout = value
+	case "branch":
+		repo.Branch = value
+	case "priority":
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid priority", value) //nolint:goerr113
+		}
+		repo.Priority = priority
+	case "keys":
+		repo.Keys = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	default:
+		return fmt.Errorf("unknown repo key %q", key) //nolint:goerr113
+	}
+
+	return nil
+}
+
+// Source returns where the repository's files are downloaded from, {file} standing for the name of a file (ex:
+// https://github.com/<user>/<repo>/raw/main/{file}). It's empty if the repository has neither a URL nor a remote.
+func (repo Repo) Source() string {
+	baseURL, layout, branch := repo.URL, repo.Layout, repo.Branch
+
+	// A remote is laid out like a forge's repository
+	if baseURL == "" && repo.Remote != "" {
+		baseURL = "https://" + repo.Remote
+		if layout == "" {
+			layout = ForgeLayout
+		}
+	}
+
+	if baseURL == "" {
+		return ""
+	}
+
+	if layout == "" {
+		layout = DefaultLayout
+	}
+
+	if branch == "" {
+		branch = DefaultBranch
+	}
+
+	return strings.NewReplacer("{url}", strings.TrimSuffix(baseURL, "/"), "{branch}", branch).Replace(layout)
+}
+
+// repo returns the repository with the given name, it's added if it wasn't declared yet.
+func (cfg *Config) repo(name string) *Repo {
+	idx := slices.IndexFunc(cfg.Repos, func(repo Repo) bool { return repo.Name == name })
+	if idx < 0 {
+		cfg.Repos = append(cfg.Repos, Repo{Name: name})
+		idx = len(cfg.Repos) - 1
+	}
+
+	return &cfg.Repos[idx]
+}
+
+// Repo returns the repository with the given name, the second value reports whether it's declared.
+func (cfg *Config) Repo(name string) (Repo, bool) {
+	idx := slices.IndexFunc(cfg.Repos, func(repo Repo) bool { return repo.Name == name })
+	if idx < 0 {
+		return Repo{}, false
+	}
+
+	return cfg.Repos[idx], true
+}
+
+// ByPriority returns the declared repositories, the highest priority first. Repositories with the same priority keep
+// the order they are declared in.
+func (cfg *Config) ByPriority() []Repo {
+	repos := slices.Clone(cfg.Repos)
+	slices.SortStableFunc(repos, func(repo, other Repo) int { return cmp.Compare(other.Priority, repo.Priority) })
+
+	return repos
+}
+
+// RepoDB returns where the database of a repository is, in its directory of the cache.
+func (cfg *Config) RepoDB(name string) string {
+	return filepath.Join(cfg.CacheDir, name, name+".db")
+}
+
+// SetFromFlag overrides a key of the global section with the value of a command-line flag.
+func (cfg *Config) SetFromFlag(key, value string) error {
+	return cfg.set(key, value, "command line")
+}
+
+// Lines returns the effective settings as key = value lines along with where they were set from.
+func (cfg *Config) Lines() []string {
+	lines := make([]string, 0, len(settings))
+	for _, set := range settings {
+		lines = append(lines, fmt.Sprintf("%s = %s\t# %s", set.key, set.format(cfg), cfg.sources[set.key]))
+	}
+
+	for _, repo := range cfg.Repos {
+		lines = append(lines,
+			"",
+			fmt.Sprintf("[repo %s]\t# %s", repo.Name, cfg.sources["repo "+repo.Name]),
+			"remote = "+repo.Remote,
+			"url = "+repo.URL,
+			"layout = "+repo.Layout,
+			"branch = "+repo.Branch,
+			"priority = "+strconv.Itoa(repo.Priority),
+			"keys = "+strings.Join(repo.Keys, ", "),
+			"# files are fetched from "+repo.Source(),
+		)
+	}
+
+	return lines
+}
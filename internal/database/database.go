@@ -18,8 +18,19 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 
 	_ "github.com/mattn/go-sqlite3" // Driver for sqlite
+	"github.com/redds-be/rpkgm/internal/version"
+)
+
+// DepKindRuntime is the kind of the dependencies listed in a package's dependencies field.
+const DepKindRuntime = "runtime"
+
+// Reasons for which a package was installed.
+const (
+	ReasonExplicit   = "explicit"
+	ReasonDependency = "dependency"
 )
 
 // Package defines a package in the database.
@@ -39,6 +50,7 @@ type Packages struct {
 }
 
 // PkgInfo defines the basic information about a give package.
+// Repo is the repository the information comes from, InstalledRepo the one the installed version came from.
 type PkgInfo struct {
 	Name             string
 	Description      string
@@ -49,15 +61,56 @@ type PkgInfo struct {
 	ArchiveURL       string
 	Sha512           string
 	Dependencies     string
+	InstallReason    string
+	Repo             string
+	InstalledRepo    string
+	InstallDate      string
+}
+
+// Dependency defines a dependency relation between two packages.
+type Dependency struct {
+	Package    string
+	DependsOn  string
+	Constraint string
+	Kind       string
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx, so that the same methods work in and out of a transaction.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
 
 // Adapter implements the DBPort interface.
 type Adapter struct {
-	dbase *sql.DB
+	conn       *sql.DB
+	dbase      querier
+	migrations []Migration
 }
 
-// NewAdapter creates a new Adapter.
+// NewAdapter creates a new Adapter for a repository's database and brings its schema up to date.
 func NewAdapter(driverName, dataSourceName string) (*Adapter, error) {
+	return migrated(OpenAdapter(driverName, dataSourceName))
+}
+
+// NewLocalAdapter creates a new Adapter for the local database and brings its schema up to date.
+func NewLocalAdapter(driverName, dataSourceName string) (*Adapter, error) {
+	return migrated(OpenLocalAdapter(driverName, dataSourceName))
+}
+
+// OpenAdapter creates a new Adapter for a repository's database without touching its schema.
+func OpenAdapter(driverName, dataSourceName string) (*Adapter, error) {
+	return open(driverName, dataSourceName, repoMigrations)
+}
+
+// OpenLocalAdapter creates a new Adapter for the local database without touching its schema.
+func OpenLocalAdapter(driverName, dataSourceName string) (*Adapter, error) {
+	return open(driverName, dataSourceName, localMigrations)
+}
+
+// open creates a new Adapter whose schema is defined by the given migrations.
+func open(driverName, dataSourceName string, migrations []Migration) (*Adapter, error) {
 	// Connect to the database
 	dbase, err := sql.Open(driverName, dataSourceName)
 	if err != nil {
@@ -70,91 +123,202 @@ func NewAdapter(driverName, dataSourceName string) (*Adapter, error) {
 		return nil, err
 	}
 
-	return &Adapter{dbase: dbase}, nil
+	return &Adapter{conn: dbase, dbase: dbase, migrations: migrations}, nil
+}
+
+// migrated applies the pending migrations of a newly opened Adapter, if any.
+func migrated(dbAdapter *Adapter, err error) (*Adapter, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = dbAdapter.Migrate()
+	if err != nil {
+		return nil, errors.Join(err, dbAdapter.CloseDBConnection())
+	}
+
+	return dbAdapter, nil
 }
 
 // CloseDBConnection closes the db connection.
 func (dbAdapter Adapter) CloseDBConnection() error {
 	// Close the database
-	err := dbAdapter.dbase.Close()
+	err := dbAdapter.conn.Close()
 
 	return err
 }
 
-// CreatePkgTable create the packages table.
-func (dbAdapter Adapter) CreatePkgTable() error {
-	const queryString = `CREATE TABLE IF NOT EXISTS packages (
-    name VARCHAR(512) PRIMARY KEY,
-    description VARCHAR(8000) NOT NULL,
-    repoVersion VARCHAR(16) NOT NULL,
-    installedVersion VARCHAR(16),
-    installed BOOLEAN NOT NULL,
-    buildFilesDir VARCHAR(4096) NOT NULL,
-    archiveURL VARCHAR(8000) NOT NULL,
-    sha512 VARCHAR(128) NOT NULL,
-    dependencies VARCHAR(8000) NOT NULL
-    );`
-	_, err := dbAdapter.dbase.Exec(queryString)
+// WithTx runs a function in a transaction, the adapter given to the function has its methods scoped to the
+// transaction. The transaction is committed if the function succeeds and rolled back otherwise.
+// Calling WithTx on an adapter which is already scoped to a transaction runs the function in that transaction.
+func (dbAdapter Adapter) WithTx(apply func(txAdapter *Adapter) error) error {
+	if _, isTx := dbAdapter.dbase.(*sql.Tx); isTx {
+		return apply(&dbAdapter)
+	}
 
-	return err
+	tx, err := dbAdapter.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	txAdapter := dbAdapter
+	txAdapter.dbase = tx
+
+	err = apply(&txAdapter)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+// setDeps replaces the dependencies relations of a package with the ones of the given dependencies list.
+func (dbAdapter Adapter) setDeps(name, dependencies string) error {
+	constraints, err := version.ParseConstraints(dependencies)
+	if err != nil {
+		return err
+	}
+
+	const deleteString = `DELETE FROM dependencies WHERE package = $1 AND kind = $2;`
+
+	_, err = dbAdapter.dbase.Exec(deleteString, name, DepKindRuntime)
+	if err != nil {
+		return err
+	}
+
+	const insertString = `INSERT OR REPLACE INTO dependencies VALUES ($1, $2, $3, $4);`
+
+	for _, constraint := range constraints {
+		_, err = dbAdapter.dbase.Exec(
+			insertString,
+			name,
+			constraint.Name,
+			constraint.Op+constraint.Version,
+			DepKindRuntime,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryDeps returns the dependencies relations returned by a query.
+func (dbAdapter Adapter) queryDeps(queryString string, args ...any) ([]Dependency, error) {
+	var deps []Dependency
+
+	// Get the row results of the query
+	rows, err := dbAdapter.dbase.Query(queryString, args...) //nolint:sqlclosecheck
+	if err != nil {
+		return nil, err
+	}
+
+	// Defer the closing of the rows
+	defer func(rows *sql.Rows) {
+		err = rows.Close()
+	}(rows)
+
+	if rows.Err() != nil {
+		return nil, err
+	}
+
+	// For each row, append to deps
+	for rows.Next() {
+		var dep Dependency
+		err = rows.Scan(&dep.Package, &dep.DependsOn, &dep.Constraint, &dep.Kind)
+		deps = append(deps, dep)
+	}
+
+	return deps, err
+}
+
+// GetDependencies returns the packages a given package depends on.
+func (dbAdapter Adapter) GetDependencies(name string) ([]Dependency, error) {
+	const queryString = `SELECT package, depends_on, "constraint", kind
+        FROM dependencies WHERE package = $1 ORDER BY depends_on;`
+
+	return dbAdapter.queryDeps(queryString, name)
+}
+
+// GetReverseDependencies returns the packages that depend on a given package.
+func (dbAdapter Adapter) GetReverseDependencies(name string) ([]Dependency, error) {
+	const queryString = `SELECT package, depends_on, "constraint", kind
+        FROM dependencies WHERE depends_on = $1 ORDER BY package;`
+
+	return dbAdapter.queryDeps(queryString, name)
 }
 
 // AddToRepo adds a package to the package table in the repo.
 func (dbAdapter Adapter) AddToRepo(
 	name, description, repoVersion, buildFilesDir, archiveURL, hash, dependencies string,
 ) error {
-	const queryString = `INSERT INTO packages VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`
-	_, err := dbAdapter.dbase.Exec(
-		queryString,
-		name,
-		description,
-		repoVersion,
-		"",
-		false,
-		buildFilesDir,
-		archiveURL,
-		hash,
-		dependencies,
-	)
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		const queryString = `INSERT INTO packages (
+	        name,
+	        description,
+	        repoVersion,
+	        buildFilesDir,
+	        archiveURL,
+	        sha512,
+	        dependencies
+	        ) VALUES ($1, $2, $3, $4, $5, $6, $7);`
+		_, err := txAdapter.dbase.Exec(
+			queryString,
+			name,
+			description,
+			repoVersion,
+			buildFilesDir,
+			archiveURL,
+			hash,
+			dependencies,
+		)
+		if err != nil {
+			return err
+		}
 
-	return err
+		return txAdapter.setDeps(name, dependencies)
+	})
 }
 
 // SyncRepo syncs packages in the database (using the name as the key).
 func (dbAdapter Adapter) SyncRepo(
 	name, description, repoVersion, buildFilesDir, archiveURL, hash, dependencies string,
 ) error {
-	const queryString = `UPDATE packages SET
-        description = $1,
-        repoVersion = $2,
-        buildFilesDir = $3,
-        archiveURL = $4,
-        sha512 = $5,
-        dependencies = $6 
-        WHERE name = $7;`
-
-	_, err := dbAdapter.dbase.Exec(
-		queryString,
-		description,
-		repoVersion,
-		buildFilesDir,
-		archiveURL,
-		hash,
-		dependencies,
-		name,
-	)
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		const queryString = `UPDATE packages SET
+	        description = $1,
+	        repoVersion = $2,
+	        buildFilesDir = $3,
+	        archiveURL = $4,
+	        sha512 = $5,
+	        dependencies = $6 
+	        WHERE name = $7;`
+
+		_, err := txAdapter.dbase.Exec(
+			queryString,
+			description,
+			repoVersion,
+			buildFilesDir,
+			archiveURL,
+			hash,
+			dependencies,
+			name,
+		)
+		if err != nil {
+			return err
+		}
 
-	return err
+		return txAdapter.setDeps(name, dependencies)
+	})
 }
 
-// GetPkgInfo returns the basic information about a given package.
+// GetPkgInfo returns the basic information about a given package in the repo.
 func (dbAdapter Adapter) GetPkgInfo(name string) (PkgInfo, error) {
 	const queryString = `SELECT
         name,
         description,
         repoVersion,
-        installedVersion,
-        installed,
         buildFilesDir,
         archiveURL,
         sha512,
@@ -167,8 +331,6 @@ func (dbAdapter Adapter) GetPkgInfo(name string) (PkgInfo, error) {
 		&info.Name,
 		&info.Description,
 		&info.RepoVersion,
-		&info.InstalledVersion,
-		&info.Installed,
 		&info.BuildFilesDir,
 		&info.ArchiveURL,
 		&info.Sha512,
@@ -187,64 +349,11 @@ func (dbAdapter Adapter) GetAllPkgInfo() ([]PkgInfo, error) {
         name,
         description,
         repoVersion,
-        installedVersion,
-        installed,
-        buildFilesDir,
-        archiveURL,
-        sha512,
-        dependencies
-        FROM packages;`
-
-	var infos []PkgInfo
-
-	// Get the row results of the query
-	rows, err := dbAdapter.dbase.Query(queryString) //nolint:sqlclosecheck
-	if err != nil {
-		return nil, err
-	}
-
-	// Defer the closing of the rows
-	defer func(rows *sql.Rows) {
-		err = rows.Close()
-	}(rows)
-
-	if rows.Err() != nil {
-		return nil, err
-	}
-
-	// For each row, append to info
-	for rows.Next() {
-		var info PkgInfo
-		err = rows.Scan(
-			&info.Name,
-			&info.Description,
-			&info.RepoVersion,
-			&info.InstalledVersion,
-			&info.Installed,
-			&info.BuildFilesDir,
-			&info.ArchiveURL,
-			&info.Sha512,
-			&info.Dependencies,
-		)
-		infos = append(infos, info)
-	}
-
-	return infos, err
-}
-
-// GetInstalledPkgInfo returns the basic information about every installed packages in the repo.
-func (dbAdapter Adapter) GetInstalledPkgInfo() ([]PkgInfo, error) {
-	const queryString = `SELECT
-        name,
-        description,
-        repoVersion,
-        installedVersion,
-        installed,
         buildFilesDir,
         archiveURL,
         sha512,
         dependencies
-        FROM packages WHERE installed = 1;`
+        FROM packages ORDER BY name;`
 
 	var infos []PkgInfo
 
@@ -270,8 +379,6 @@ func (dbAdapter Adapter) GetInstalledPkgInfo() ([]PkgInfo, error) {
 			&info.Name,
 			&info.Description,
 			&info.RepoVersion,
-			&info.InstalledVersion,
-			&info.Installed,
 			&info.BuildFilesDir,
 			&info.ArchiveURL,
 			&info.Sha512,
@@ -285,14 +392,24 @@ func (dbAdapter Adapter) GetInstalledPkgInfo() ([]PkgInfo, error) {
 
 // RenamePackage renames a given package.
 func (dbAdapter Adapter) RenamePackage(oldName, newName string) error {
-	const queryString = `UPDATE packages SET name = $1 WHERE name = $2;`
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		const queryString = `UPDATE packages SET name = $1 WHERE name = $2;`
 
-	_, err := dbAdapter.dbase.Exec(queryString, newName, oldName)
-	if err != nil {
-		return err
-	}
+		_, err := txAdapter.dbase.Exec(queryString, newName, oldName)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		// Its dependencies relations follow it
+		const depsQueryString = `UPDATE dependencies SET package = $1 WHERE package = $2;`
+
+		_, err = txAdapter.dbase.Exec(depsQueryString, newName, oldName)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
 }
 
 // ChangePkgDesc changes a given package's description.
@@ -316,14 +433,6 @@ func (dbAdapter Adapter) GetPkgBuildFilesDir(name string) (string, error) {
 	return buildFilesDir, nil
 }
 
-// SetInstalledVersion sets the installed version for a package.
-func (dbAdapter Adapter) SetInstalledVersion(name, version string) error {
-	const queryString = `UPDATE packages SET installedVersion = $1 WHERE name = $2;`
-	_, err := dbAdapter.dbase.Exec(queryString, version, name)
-
-	return err
-}
-
 // UpdateRepoVersion updates the repo's version of a package.
 func (dbAdapter Adapter) UpdateRepoVersion(name, version string) error {
 	const queryString = `UPDATE packages SET repoVersion = $1 WHERE name = $2;`
@@ -345,43 +454,6 @@ func (dbAdapter Adapter) IsPkgInRepo(name string) (bool, error) {
 	return true, nil
 }
 
-// IsInstalled checks if a given packages is already installed or not.
-func (dbAdapter Adapter) IsInstalled(name string) (bool, error) {
-	const queryString = `SELECT installed FROM packages WHERE name = $1;`
-	var installed bool
-
-	err := dbAdapter.dbase.QueryRow(queryString, name).Scan(&installed)
-	if err != nil {
-		return false, err
-	}
-
-	return installed, nil
-}
-
-// MarkAsInstalled marks a package as installed.
-func (dbAdapter Adapter) MarkAsInstalled(name string) error {
-	const queryString = `UPDATE packages SET installed = 1 WHERE name = $1;`
-
-	_, err := dbAdapter.dbase.Exec(queryString, name)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// MarkAsNotInstalled marks a package as installed.
-func (dbAdapter Adapter) MarkAsNotInstalled(name string) error {
-	const queryString = `UPDATE packages SET installed = 0 WHERE name = $1;`
-
-	_, err := dbAdapter.dbase.Exec(queryString, name)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 // ChangeBuildFilesDir changes the build files directory for a given package.
 func (dbAdapter Adapter) ChangeBuildFilesDir(name, buildFilesDir string) error {
 	const queryString = `UPDATE packages SET buildFilesDir = $1 WHERE name = $2;`
@@ -396,14 +468,24 @@ func (dbAdapter Adapter) ChangeBuildFilesDir(name, buildFilesDir string) error {
 
 // RemovePackage removes a given package from the repository.
 func (dbAdapter Adapter) RemovePackage(name string) error {
-	const queryString = `DELETE FROM packages WHERE name = $1;`
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		const queryString = `DELETE FROM packages WHERE name = $1;`
 
-	_, err := dbAdapter.dbase.Exec(queryString, name)
-	if err != nil {
-		return err
-	}
+		_, err := txAdapter.dbase.Exec(queryString, name)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		// Its dependencies relations go with it
+		const depsQueryString = `DELETE FROM dependencies WHERE package = $1;`
+
+		_, err = txAdapter.dbase.Exec(depsQueryString, name)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
 }
 
 // ChangeArchiveURL changes the archive url.
@@ -432,12 +514,14 @@ func (dbAdapter Adapter) ChangeHash(name, hash string) error {
 
 // ChangeDeps changes a package's dependencies list.
 func (dbAdapter Adapter) ChangeDeps(name, dependencies string) error {
-	const queryString = `UPDATE packages SET dependencies = $1 WHERE name = $2;`
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		const queryString = `UPDATE packages SET dependencies = $1 WHERE name = $2;`
 
-	_, err := dbAdapter.dbase.Exec(queryString, dependencies, name)
-	if err != nil {
-		return err
-	}
+		_, err := txAdapter.dbase.Exec(queryString, dependencies, name)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		return txAdapter.setDeps(name, dependencies)
+	})
 }
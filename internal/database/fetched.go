@@ -0,0 +1,76 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Fetched defines a file of a repository as it was fetched by the last sync: where from, the validators its server sent
+// (ETag and Last-Modified headers), to only download it again if it changed, its sha512 hash and the serial of its
+// signature, to refuse an older one.
+type Fetched struct {
+	File         string
+	URL          string
+	ETag         string
+	LastModified string
+	Sha512       string
+	Serial       int64
+}
+
+// GetFetched returns a given file as it was fetched by the last sync, an empty Fetched is returned if it never was.
+func (dbAdapter Adapter) GetFetched(file string) (Fetched, error) {
+	const queryString = `SELECT file, url, etag, lastModified, sha512, serial FROM fetched WHERE file = $1;`
+
+	fetched := Fetched{File: file}
+
+	err := dbAdapter.dbase.QueryRow(queryString, file).
+		Scan(&fetched.File, &fetched.URL, &fetched.ETag, &fetched.LastModified, &fetched.Sha512, &fetched.Serial)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Fetched{File: file}, nil
+	}
+
+	return fetched, err
+}
+
+// SetFetched records a file as it was fetched by the sync.
+func (dbAdapter Adapter) SetFetched(fetched Fetched) error {
+	const queryString = `INSERT OR REPLACE INTO fetched (file, url, etag, lastModified, sha512, serial) VALUES ($1, $2, $3, $4, $5, $6);`
+
+	_, err := dbAdapter.dbase.Exec(
+		queryString,
+		fetched.File,
+		fetched.URL,
+		fetched.ETag,
+		fetched.LastModified,
+		fetched.Sha512,
+		fetched.Serial,
+	)
+
+	return err
+}
+
+// ClearFetched forgets how the repository's files were fetched, the next sync downloads them again.
+// The serials of their signatures are kept, so an older signature is still refused.
+func (dbAdapter Adapter) ClearFetched() error {
+	const queryString = `UPDATE fetched SET url = '', etag = '', lastModified = '', sha512 = '';`
+
+	_, err := dbAdapter.dbase.Exec(queryString)
+
+	return err
+}
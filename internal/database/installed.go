@@ -0,0 +1,188 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// InstalledPkg defines a package installed on the system, as recorded in the local database.
+// Repo is the repository it was installed from and Dependencies its dependencies list at that time.
+// Date is when it was installed (RFC 3339), it is empty for packages installed before it was recorded.
+type InstalledPkg struct {
+	Name         string
+	Version      string
+	Reason       string
+	Repo         string
+	Dependencies string
+	Date         string
+}
+
+// GetInstalled returns a given installed package, sql.ErrNoRows is returned if it isn't installed.
+func (dbAdapter Adapter) GetInstalled(name string) (InstalledPkg, error) {
+	const queryString = `SELECT name, version, reason, repo, dependencies, installDate FROM installed WHERE name = $1;`
+
+	var installed InstalledPkg
+
+	err := dbAdapter.dbase.QueryRow(queryString, name).Scan(
+		&installed.Name,
+		&installed.Version,
+		&installed.Reason,
+		&installed.Repo,
+		&installed.Dependencies,
+		&installed.Date,
+	)
+	if err != nil {
+		return InstalledPkg{}, err
+	}
+
+	return installed, nil
+}
+
+// GetAllInstalled returns every installed package, sorted by name.
+func (dbAdapter Adapter) GetAllInstalled() ([]InstalledPkg, error) {
+	const queryString = `SELECT name, version, reason, repo, dependencies, installDate FROM installed ORDER BY name;`
+
+	var installedPkgs []InstalledPkg
+
+	// Get the row results of the query
+	rows, err := dbAdapter.dbase.Query(queryString) //nolint:sqlclosecheck
+	if err != nil {
+		return nil, err
+	}
+
+	// Defer the closing of the rows
+	defer func(rows *sql.Rows) {
+		err = rows.Close()
+	}(rows)
+
+	if rows.Err() != nil {
+		return nil, err
+	}
+
+	// For each row, append to installedPkgs
+	for rows.Next() {
+		var installed InstalledPkg
+		err = rows.Scan(
+			&installed.Name,
+			&installed.Version,
+			&installed.Reason,
+			&installed.Repo,
+			&installed.Dependencies,
+			&installed.Date,
+		)
+		installedPkgs = append(installedPkgs, installed)
+	}
+
+	return installedPkgs, err
+}
+
+// IsInstalled checks if a given packages is already installed or not.
+func (dbAdapter Adapter) IsInstalled(name string) (bool, error) {
+	_, err := dbAdapter.GetInstalled(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SetInstalled records a package as installed, replacing what was recorded about it.
+func (dbAdapter Adapter) SetInstalled(installed InstalledPkg) error {
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		const queryString = `INSERT OR REPLACE INTO installed (
+	        name,
+	        version,
+	        reason,
+	        repo,
+	        dependencies,
+	        installDate
+	        ) VALUES ($1, $2, $3, $4, $5, $6);`
+
+		_, err := txAdapter.dbase.Exec(
+			queryString,
+			installed.Name,
+			installed.Version,
+			installed.Reason,
+			installed.Repo,
+			installed.Dependencies,
+			installed.Date,
+		)
+		if err != nil {
+			return err
+		}
+
+		return txAdapter.setDeps(installed.Name, installed.Dependencies)
+	})
+}
+
+// RemoveInstalled records a package as not installed anymore.
+func (dbAdapter Adapter) RemoveInstalled(name string) error {
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		_, err := txAdapter.dbase.Exec(`DELETE FROM installed WHERE name = $1;`, name)
+		if err != nil {
+			return err
+		}
+
+		// Its dependencies relations go with it
+		_, err = txAdapter.dbase.Exec(`DELETE FROM dependencies WHERE package = $1;`, name)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// SetInstallReason sets the reason for which a package is installed (ReasonExplicit or ReasonDependency),
+// sql.ErrNoRows is returned if it isn't installed.
+func (dbAdapter Adapter) SetInstallReason(name, reason string) error {
+	const queryString = `UPDATE installed SET reason = $1 WHERE name = $2;`
+
+	return dbAdapter.updateInstalled(queryString, reason, name)
+}
+
+// SetInstalledVersion sets the installed version for a package, sql.ErrNoRows is returned if it isn't installed.
+func (dbAdapter Adapter) SetInstalledVersion(name, version string) error {
+	const queryString = `UPDATE installed SET version = $1 WHERE name = $2;`
+
+	return dbAdapter.updateInstalled(queryString, version, name)
+}
+
+// updateInstalled runs a query updating an installed package, sql.ErrNoRows is returned if no package was updated.
+func (dbAdapter Adapter) updateInstalled(queryString string, args ...any) error {
+	result, err := dbAdapter.dbase.Exec(queryString, args...)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,426 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// States of a transaction.
+const (
+	TxRunning    = "running"
+	TxDone       = "done"
+	TxRolledBack = "rolled back"
+)
+
+// States of a step of a transaction.
+const (
+	StepPending  = "pending"
+	StepApplying = "applying"
+	StepDone     = "done"
+)
+
+// States of a path saved before a step changed it.
+const (
+	PathAbsent = "absent"
+	PathSaved  = "saved"
+)
+
+// Transaction defines an install or uninstall operation recorded in the journal.
+type Transaction struct {
+	ID        int64
+	Operation string
+	State     string
+	BackupDir string
+	Started   string
+}
+
+// JournalStep defines a package of a transaction along with its state before the transaction changed it.
+// Repo is the repository the package is installed from, empty when uninstalling.
+type JournalStep struct {
+	TxID             int64
+	Seq              int
+	Package          string
+	Repo             string
+	Reason           string
+	State            string
+	PrevInstalled    bool
+	PrevVersion      string
+	PrevReason       string
+	PrevRepo         string
+	PrevDependencies string
+	PrevInstallDate  string
+}
+
+// JournalPath defines a path as it was before a step changed it, saved paths have a copy in the backup directory.
+type JournalPath struct {
+	TxID   int64
+	Seq    int
+	Idx    int
+	Path   string
+	State  string
+	Type   string
+	Mode   uint32
+	Target string
+}
+
+// BeginTransaction records a new running transaction along with its pending steps and returns its id.
+func (dbAdapter Adapter) BeginTransaction(operation, started string, steps []JournalStep) (int64, error) {
+	var txID int64
+
+	err := dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		const queryString = `INSERT INTO transactions (operation, state, backupDir, started) VALUES ($1, $2, '', $3);`
+
+		res, err := txAdapter.dbase.Exec(queryString, operation, TxRunning, started)
+		if err != nil {
+			return err
+		}
+
+		txID, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		const stepQueryString = `INSERT INTO journal (txid, seq, package, repo, reason, state)
+	        VALUES ($1, $2, $3, $4, $5, $6);`
+
+		for _, step := range steps {
+			_, err = txAdapter.dbase.Exec(stepQueryString, txID, step.Seq, step.Package, step.Repo, step.Reason, StepPending)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return txID, nil
+}
+
+// SetTransactionBackupDir sets the directory the files replaced by a transaction are saved into.
+func (dbAdapter Adapter) SetTransactionBackupDir(txID int64, backupDir string) error {
+	const queryString = `UPDATE transactions SET backupDir = $1 WHERE id = $2;`
+
+	_, err := dbAdapter.dbase.Exec(queryString, backupDir, txID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetTransactionState sets the state of a transaction (TxRunning, TxDone or TxRolledBack).
+func (dbAdapter Adapter) SetTransactionState(txID int64, state string) error {
+	const queryString = `UPDATE transactions SET state = $1 WHERE id = $2;`
+
+	_, err := dbAdapter.dbase.Exec(queryString, state, txID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetUnfinishedTransaction returns the transaction that is still running, nil is returned if there is none.
+func (dbAdapter Adapter) GetUnfinishedTransaction() (*Transaction, error) {
+	const queryString = `SELECT id, operation, state, backupDir, started
+        FROM transactions WHERE state = $1 ORDER BY id LIMIT 1;`
+
+	var txn Transaction
+
+	err := dbAdapter.dbase.QueryRow(queryString, TxRunning).Scan(
+		&txn.ID,
+		&txn.Operation,
+		&txn.State,
+		&txn.BackupDir,
+		&txn.Started,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil //nolint:nilnil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &txn, nil
+}
+
+// GetJournalSteps returns the steps of a transaction, in order.
+func (dbAdapter Adapter) GetJournalSteps(txID int64) ([]JournalStep, error) {
+	const queryString = `SELECT
+        txid,
+        seq,
+        package,
+        repo,
+        reason,
+        state,
+        prevInstalled,
+        prevVersion,
+        prevReason,
+        prevRepo,
+        prevDependencies,
+        prevInstallDate
+        FROM journal WHERE txid = $1 ORDER BY seq;`
+
+	var steps []JournalStep
+
+	// Get the row results of the query
+	rows, err := dbAdapter.dbase.Query(queryString, txID) //nolint:sqlclosecheck
+	if err != nil {
+		return nil, err
+	}
+
+	// Defer the closing of the rows
+	defer func(rows *sql.Rows) {
+		err = rows.Close()
+	}(rows)
+
+	if rows.Err() != nil {
+		return nil, err
+	}
+
+	// For each row, append to steps
+	for rows.Next() {
+		var step JournalStep
+		err = rows.Scan(
+			&step.TxID,
+			&step.Seq,
+			&step.Package,
+			&step.Repo,
+			&step.Reason,
+			&step.State,
+			&step.PrevInstalled,
+			&step.PrevVersion,
+			&step.PrevReason,
+			&step.PrevRepo,
+			&step.PrevDependencies,
+			&step.PrevInstallDate,
+		)
+		steps = append(steps, step)
+	}
+
+	return steps, err
+}
+
+// StartStep records the state of a step's package before the step changes it (the Prev fields of the step along with
+// its manifest) and marks the step as applying.
+func (dbAdapter Adapter) StartStep(step JournalStep, manifest []ManifestEntry) error {
+	txID, seq := step.TxID, step.Seq
+
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		const queryString = `UPDATE journal SET
+	        state = $1,
+	        prevInstalled = $2,
+	        prevVersion = $3,
+	        prevReason = $4,
+	        prevRepo = $5,
+	        prevDependencies = $6,
+	        prevInstallDate = $7
+	        WHERE txid = $8 AND seq = $9;`
+
+		_, err := txAdapter.dbase.Exec(
+			queryString,
+			StepApplying,
+			step.PrevInstalled,
+			step.PrevVersion,
+			step.PrevReason,
+			step.PrevRepo,
+			step.PrevDependencies,
+			step.PrevInstallDate,
+			txID,
+			seq,
+		)
+		if err != nil {
+			return err
+		}
+
+		// The step may have been started before being interrupted
+		_, err = txAdapter.dbase.Exec(`DELETE FROM journal_manifest WHERE txid = $1 AND seq = $2;`, txID, seq)
+		if err != nil {
+			return err
+		}
+
+		const manifestQueryString = `INSERT INTO journal_manifest VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`
+
+		for _, entry := range manifest {
+			_, err = txAdapter.dbase.Exec(
+				manifestQueryString,
+				txID,
+				seq,
+				entry.Package,
+				entry.Path,
+				entry.Type,
+				entry.Mode,
+				entry.Size,
+				entry.Sha512,
+				entry.Target,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// SetStepState sets the state of a step (StepPending, StepApplying or StepDone).
+func (dbAdapter Adapter) SetStepState(txID int64, seq int, state string) error {
+	const queryString = `UPDATE journal SET state = $1 WHERE txid = $2 AND seq = $3;`
+
+	_, err := dbAdapter.dbase.Exec(queryString, state, txID, seq)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetJournalManifest returns the manifest a step's package had before the step changed it.
+func (dbAdapter Adapter) GetJournalManifest(txID int64, seq int) ([]ManifestEntry, error) {
+	const queryString = `SELECT package, path, type, mode, size, sha512, target
+        FROM journal_manifest WHERE txid = $1 AND seq = $2 ORDER BY path;`
+
+	return dbAdapter.queryManifest(queryString, txID, seq)
+}
+
+// AddJournalPath records the state of a path before a step changes it.
+func (dbAdapter Adapter) AddJournalPath(path JournalPath) error {
+	const queryString = `INSERT OR REPLACE INTO journal_paths VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`
+
+	_, err := dbAdapter.dbase.Exec(
+		queryString,
+		path.TxID,
+		path.Seq,
+		path.Idx,
+		path.Path,
+		path.State,
+		path.Type,
+		path.Mode,
+		path.Target,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetJournalPaths returns the paths recorded for a step, in the order they were recorded.
+func (dbAdapter Adapter) GetJournalPaths(txID int64, seq int) ([]JournalPath, error) {
+	const queryString = `SELECT txid, seq, idx, path, state, type, mode, target
+        FROM journal_paths WHERE txid = $1 AND seq = $2 ORDER BY idx;`
+
+	var paths []JournalPath
+
+	// Get the row results of the query
+	rows, err := dbAdapter.dbase.Query(queryString, txID, seq) //nolint:sqlclosecheck
+	if err != nil {
+		return nil, err
+	}
+
+	// Defer the closing of the rows
+	defer func(rows *sql.Rows) {
+		err = rows.Close()
+	}(rows)
+
+	if rows.Err() != nil {
+		return nil, err
+	}
+
+	// For each row, append to paths
+	for rows.Next() {
+		var path JournalPath
+		err = rows.Scan(
+			&path.TxID,
+			&path.Seq,
+			&path.Idx,
+			&path.Path,
+			&path.State,
+			&path.Type,
+			&path.Mode,
+			&path.Target,
+		)
+		paths = append(paths, path)
+	}
+
+	return paths, err
+}
+
+// RemoveJournalPaths forgets the paths recorded for a step.
+func (dbAdapter Adapter) RemoveJournalPaths(txID int64, seq int) error {
+	const queryString = `DELETE FROM journal_paths WHERE txid = $1 AND seq = $2;`
+
+	_, err := dbAdapter.dbase.Exec(queryString, txID, seq)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RestoreStep puts a step's package back in the state it was in before the step changed it.
+func (dbAdapter Adapter) RestoreStep(step JournalStep) error {
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		var err error
+		if step.PrevInstalled {
+			err = txAdapter.SetInstalled(InstalledPkg{
+				Name:         step.Package,
+				Version:      step.PrevVersion,
+				Reason:       step.PrevReason,
+				Repo:         step.PrevRepo,
+				Dependencies: step.PrevDependencies,
+				Date:         step.PrevInstallDate,
+			})
+		} else {
+			err = txAdapter.RemoveInstalled(step.Package)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		manifest, err := txAdapter.GetJournalManifest(step.TxID, step.Seq)
+		if err != nil {
+			return err
+		}
+
+		return txAdapter.SetManifest(step.Package, manifest)
+	})
+}
+
+// ClearJournal forgets the saved paths and manifests of a finished transaction, its steps are kept as history.
+func (dbAdapter Adapter) ClearJournal(txID int64) error {
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		_, err := txAdapter.dbase.Exec(`DELETE FROM journal_paths WHERE txid = $1;`, txID)
+		if err != nil {
+			return err
+		}
+
+		_, err = txAdapter.dbase.Exec(`DELETE FROM journal_manifest WHERE txid = $1;`, txID)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,194 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Types of the entries of a package's manifest.
+const (
+	EntryFile    = "file"
+	EntryDir     = "dir"
+	EntrySymlink = "symlink"
+)
+
+// ManifestEntry defines a file, directory or symlink installed by a package.
+// Path is absolute, relative to the root the package was installed into.
+type ManifestEntry struct {
+	Package string
+	Path    string
+	Type    string
+	Mode    uint32
+	Size    int64
+	Sha512  string
+	Target  string
+}
+
+// SetManifest replaces the manifest of a given package.
+func (dbAdapter Adapter) SetManifest(name string, entries []ManifestEntry) error {
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		err := txAdapter.RemoveManifest(name)
+		if err != nil {
+			return err
+		}
+
+		const queryString = `INSERT INTO manifest VALUES ($1, $2, $3, $4, $5, $6, $7);`
+
+		for _, entry := range entries {
+			_, err = txAdapter.dbase.Exec(
+				queryString,
+				name,
+				entry.Path,
+				entry.Type,
+				entry.Mode,
+				entry.Size,
+				entry.Sha512,
+				entry.Target,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// RemoveManifest removes the manifest of a given package.
+func (dbAdapter Adapter) RemoveManifest(name string) error {
+	const queryString = `DELETE FROM manifest WHERE package = $1;`
+
+	_, err := dbAdapter.dbase.Exec(queryString, name)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// queryManifest returns the manifest entries returned by a query.
+func (dbAdapter Adapter) queryManifest(queryString string, args ...any) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+
+	// Get the row results of the query
+	rows, err := dbAdapter.dbase.Query(queryString, args...) //nolint:sqlclosecheck
+	if err != nil {
+		return nil, err
+	}
+
+	// Defer the closing of the rows
+	defer func(rows *sql.Rows) {
+		err = rows.Close()
+	}(rows)
+
+	if rows.Err() != nil {
+		return nil, err
+	}
+
+	// For each row, append to entries
+	for rows.Next() {
+		var entry ManifestEntry
+		err = rows.Scan(
+			&entry.Package,
+			&entry.Path,
+			&entry.Type,
+			&entry.Mode,
+			&entry.Size,
+			&entry.Sha512,
+			&entry.Target,
+		)
+		entries = append(entries, entry)
+	}
+
+	return entries, err
+}
+
+// GetManifest returns the manifest of a given package, sorted by path.
+func (dbAdapter Adapter) GetManifest(name string) ([]ManifestEntry, error) {
+	const queryString = `SELECT package, path, type, mode, size, sha512, target
+        FROM manifest WHERE package = $1 ORDER BY path;`
+
+	return dbAdapter.queryManifest(queryString, name)
+}
+
+// GetPathOwners returns the packages whose manifest contains a given path.
+func (dbAdapter Adapter) GetPathOwners(path string) ([]string, error) {
+	const queryString = `SELECT DISTINCT package FROM manifest WHERE path = $1 ORDER BY package;`
+
+	var owners []string
+
+	// Get the row results of the query
+	rows, err := dbAdapter.dbase.Query(queryString, path) //nolint:sqlclosecheck
+	if err != nil {
+		return nil, err
+	}
+
+	// Defer the closing of the rows
+	defer func(rows *sql.Rows) {
+		err = rows.Close()
+	}(rows)
+
+	if rows.Err() != nil {
+		return nil, err
+	}
+
+	// For each row, append to owners
+	for rows.Next() {
+		var owner string
+		err = rows.Scan(&owner)
+		owners = append(owners, owner)
+	}
+
+	return owners, err
+}
+
+// GetOwnedPaths returns every path owned by a package under a given directory (the directory included).
+func (dbAdapter Adapter) GetOwnedPaths(dir string) ([]string, error) {
+	// LIKE is case insensitive, so compare the beginning of the paths instead
+	const queryString = `SELECT DISTINCT path FROM manifest
+        WHERE path = $1 OR substr(path, 1, length($2)) = $2 ORDER BY path;`
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+
+	var paths []string
+
+	// Get the row results of the query
+	rows, err := dbAdapter.dbase.Query(queryString, dir, prefix) //nolint:sqlclosecheck
+	if err != nil {
+		return nil, err
+	}
+
+	// Defer the closing of the rows
+	defer func(rows *sql.Rows) {
+		err = rows.Close()
+	}(rows)
+
+	if rows.Err() != nil {
+		return nil, err
+	}
+
+	// For each row, append to paths
+	for rows.Next() {
+		var path string
+		err = rows.Scan(&path)
+		paths = append(paths, path)
+	}
+
+	return paths, err
+}
@@ -0,0 +1,443 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/redds-be/rpkgm/internal/version"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer rpkgm.
+var ErrSchemaTooNew = errors.New("the database schema is newer than the one supported by this rpkgm")
+
+// Migration defines an upgrade step of the database schema.
+type Migration struct {
+	Version     int
+	Description string
+	apply       func(tx *sql.Tx) error
+}
+
+// repoMigrations lists every migration of the repositories' databases, in order.
+// Never edit or remove one, append a new one instead.
+var repoMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "create the packages table",
+		apply: func(tx *sql.Tx) error {
+			const queryString = `CREATE TABLE IF NOT EXISTS packages (
+    name VARCHAR(512) PRIMARY KEY,
+    description VARCHAR(8000) NOT NULL,
+    repoVersion VARCHAR(16) NOT NULL,
+    installedVersion VARCHAR(16),
+    installed BOOLEAN NOT NULL,
+    buildFilesDir VARCHAR(4096) NOT NULL,
+    archiveURL VARCHAR(8000) NOT NULL,
+    sha512 VARCHAR(128) NOT NULL,
+    dependencies VARCHAR(8000) NOT NULL
+    );`
+			_, err := tx.Exec(queryString)
+
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "create the dependencies table and fill it from the packages' dependencies lists",
+		apply: func(tx *sql.Tx) error {
+			const queryString = `CREATE TABLE IF NOT EXISTS dependencies (
+    package VARCHAR(512) NOT NULL,
+    depends_on VARCHAR(512) NOT NULL,
+    "constraint" VARCHAR(512) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    PRIMARY KEY (package, depends_on, kind)
+    );
+    CREATE INDEX IF NOT EXISTS dependencies_depends_on ON dependencies (depends_on);`
+			_, err := tx.Exec(queryString)
+			if err != nil {
+				return err
+			}
+
+			return backfillDeps(tx)
+		},
+	},
+	{
+		Version:     3,
+		Description: "add the install reason of the packages",
+		apply: func(tx *sql.Tx) error {
+			// Packages installed before this migration are considered as explicitly installed, so they are never autoremoved
+			const queryString = `ALTER TABLE packages ADD COLUMN installReason VARCHAR(16) NOT NULL DEFAULT 'explicit';`
+			_, err := tx.Exec(queryString)
+
+			return err
+		},
+	},
+	{
+		Version:     4,
+		Description: "create the manifest table, which holds the files installed by the packages",
+		apply: func(tx *sql.Tx) error {
+			const queryString = `CREATE TABLE IF NOT EXISTS manifest (
+    package VARCHAR(512) NOT NULL,
+    path VARCHAR(4096) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    mode INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    sha512 VARCHAR(128) NOT NULL,
+    target VARCHAR(4096) NOT NULL,
+    PRIMARY KEY (package, path)
+    );
+    CREATE INDEX IF NOT EXISTS manifest_path ON manifest (path);`
+			_, err := tx.Exec(queryString)
+
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "create the transactions and journal tables, which allow interrupted operations to be rolled back",
+		apply: func(tx *sql.Tx) error {
+			const queryString = `CREATE TABLE IF NOT EXISTS transactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    operation VARCHAR(16) NOT NULL,
+    state VARCHAR(16) NOT NULL,
+    backupDir VARCHAR(4096) NOT NULL,
+    started VARCHAR(64) NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS journal (
+    txid INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    package VARCHAR(512) NOT NULL,
+    reason VARCHAR(16) NOT NULL,
+    state VARCHAR(16) NOT NULL,
+    prevInstalled BOOLEAN NOT NULL DEFAULT 0,
+    prevVersion VARCHAR(16) NOT NULL DEFAULT '',
+    prevReason VARCHAR(16) NOT NULL DEFAULT '',
+    PRIMARY KEY (txid, seq)
+    );
+    CREATE TABLE IF NOT EXISTS journal_manifest (
+    txid INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    package VARCHAR(512) NOT NULL,
+    path VARCHAR(4096) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    mode INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    sha512 VARCHAR(128) NOT NULL,
+    target VARCHAR(4096) NOT NULL,
+    PRIMARY KEY (txid, seq, path)
+    );
+    CREATE TABLE IF NOT EXISTS journal_paths (
+    txid INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    idx INTEGER NOT NULL,
+    path VARCHAR(4096) NOT NULL,
+    state VARCHAR(16) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    mode INTEGER NOT NULL,
+    target VARCHAR(4096) NOT NULL,
+    PRIMARY KEY (txid, seq, idx)
+    );`
+			_, err := tx.Exec(queryString)
+
+			return err
+		},
+	},
+	{
+		Version:     6,
+		Description: "drop the installed state, the files and the journal of the packages, they are kept in the local database",
+		apply: func(tx *sql.Tx) error {
+			// What is installed was copied to the local database when it was created, see CreateLocal
+			const queryString = `ALTER TABLE packages DROP COLUMN installedVersion;
+    ALTER TABLE packages DROP COLUMN installed;
+    ALTER TABLE packages DROP COLUMN installReason;
+    DROP TABLE IF EXISTS manifest;
+    DROP TABLE IF EXISTS journal_paths;
+    DROP TABLE IF EXISTS journal_manifest;
+    DROP TABLE IF EXISTS journal;
+    DROP TABLE IF EXISTS transactions;`
+			_, err := tx.Exec(queryString)
+
+			return err
+		},
+	},
+	{
+		Version:     7,
+		Description: "create the fetched table, which holds what identifies the repository's files fetched by the last sync",
+		apply: func(tx *sql.Tx) error {
+			const queryString = `CREATE TABLE IF NOT EXISTS fetched (
+    file VARCHAR(512) PRIMARY KEY,
+    url VARCHAR(4096) NOT NULL,
+    etag VARCHAR(1024) NOT NULL,
+    lastModified VARCHAR(64) NOT NULL,
+    sha512 VARCHAR(128) NOT NULL
+    );`
+			_, err := tx.Exec(queryString)
+
+			return err
+		},
+	},
+	{
+		Version:     8,
+		Description: "add the serial of the signatures of the repository's files accepted by the last sync",
+		apply: func(tx *sql.Tx) error {
+			const queryString = `ALTER TABLE fetched ADD COLUMN serial INTEGER NOT NULL DEFAULT 0;`
+			_, err := tx.Exec(queryString)
+
+			return err
+		},
+	},
+}
+
+// legacySchemaVersion is the last schema version of the repositories' databases which holds what is installed.
+const legacySchemaVersion = 5
+
+// localMigrations lists every migration of the local database, which holds what is installed, in order.
+// Never edit or remove one, append a new one instead.
+var localMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "create the installed packages, dependencies, manifest, transactions and journal tables",
+		apply: func(tx *sql.Tx) error {
+			const queryString = `CREATE TABLE IF NOT EXISTS installed (
+    name VARCHAR(512) PRIMARY KEY,
+    version VARCHAR(16) NOT NULL,
+    reason VARCHAR(16) NOT NULL,
+    repo VARCHAR(512) NOT NULL,
+    dependencies VARCHAR(8000) NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS dependencies (
+    package VARCHAR(512) NOT NULL,
+    depends_on VARCHAR(512) NOT NULL,
+    "constraint" VARCHAR(512) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    PRIMARY KEY (package, depends_on, kind)
+    );
+    CREATE INDEX IF NOT EXISTS dependencies_depends_on ON dependencies (depends_on);
+    CREATE TABLE IF NOT EXISTS manifest (
+    package VARCHAR(512) NOT NULL,
+    path VARCHAR(4096) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    mode INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    sha512 VARCHAR(128) NOT NULL,
+    target VARCHAR(4096) NOT NULL,
+    PRIMARY KEY (package, path)
+    );
+    CREATE INDEX IF NOT EXISTS manifest_path ON manifest (path);
+    CREATE TABLE IF NOT EXISTS transactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    operation VARCHAR(16) NOT NULL,
+    state VARCHAR(16) NOT NULL,
+    backupDir VARCHAR(4096) NOT NULL,
+    started VARCHAR(64) NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS journal (
+    txid INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    package VARCHAR(512) NOT NULL,
+    repo VARCHAR(512) NOT NULL,
+    reason VARCHAR(16) NOT NULL,
+    state VARCHAR(16) NOT NULL,
+    prevInstalled BOOLEAN NOT NULL DEFAULT 0,
+    prevVersion VARCHAR(16) NOT NULL DEFAULT '',
+    prevReason VARCHAR(16) NOT NULL DEFAULT '',
+    prevRepo VARCHAR(512) NOT NULL DEFAULT '',
+    prevDependencies VARCHAR(8000) NOT NULL DEFAULT '',
+    PRIMARY KEY (txid, seq)
+    );
+    CREATE TABLE IF NOT EXISTS journal_manifest (
+    txid INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    package VARCHAR(512) NOT NULL,
+    path VARCHAR(4096) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    mode INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    sha512 VARCHAR(128) NOT NULL,
+    target VARCHAR(4096) NOT NULL,
+    PRIMARY KEY (txid, seq, path)
+    );
+    CREATE TABLE IF NOT EXISTS journal_paths (
+    txid INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    idx INTEGER NOT NULL,
+    path VARCHAR(4096) NOT NULL,
+    state VARCHAR(16) NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    mode INTEGER NOT NULL,
+    target VARCHAR(4096) NOT NULL,
+    PRIMARY KEY (txid, seq, idx)
+    );`
+			_, err := tx.Exec(queryString)
+
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "add the date the packages were installed at",
+		apply: func(tx *sql.Tx) error {
+			// The date packages were installed at before this migration is unknown
+			const queryString = `ALTER TABLE installed ADD COLUMN installDate VARCHAR(64) NOT NULL DEFAULT '';
+    ALTER TABLE journal ADD COLUMN prevInstallDate VARCHAR(64) NOT NULL DEFAULT '';`
+			_, err := tx.Exec(queryString)
+
+			return err
+		},
+	},
+}
+
+// backfillDeps fills the dependencies table using the dependencies lists of the packages table.
+func backfillDeps(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT name, dependencies FROM packages;`) //nolint:sqlclosecheck
+	if err != nil {
+		return err
+	}
+
+	// Read every row before writing, the rows have to be closed before using the transaction again
+	depsLists := make(map[string]string)
+	for rows.Next() {
+		var name, deps string
+		if err = rows.Scan(&name, &deps); err != nil {
+			_ = rows.Close()
+
+			return err
+		}
+		depsLists[name] = deps
+	}
+
+	if err = rows.Close(); err != nil {
+		return err
+	}
+
+	for name, deps := range depsLists {
+		// An invalid list can't be expressed as relations, it'll be fixed on the next sync or manage --deps
+		constraints, err := version.ParseConstraints(deps)
+		if err != nil {
+			continue
+		}
+
+		for _, constraint := range constraints {
+			_, err = tx.Exec(
+				`INSERT OR REPLACE INTO dependencies VALUES ($1, $2, $3, $4);`,
+				name,
+				constraint.Name,
+				constraint.Op+constraint.Version,
+				DepKindRuntime,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// LatestSchemaVersion returns the schema version the database is at once every migration is applied.
+func (dbAdapter Adapter) LatestSchemaVersion() int {
+	return dbAdapter.migrations[len(dbAdapter.migrations)-1].Version
+}
+
+// SchemaVersion returns the current schema version of the database.
+func (dbAdapter Adapter) SchemaVersion() (int, error) {
+	var schemaVersion int
+
+	err := dbAdapter.dbase.QueryRow(`PRAGMA user_version;`).Scan(&schemaVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	return schemaVersion, nil
+}
+
+// PendingMigrations returns the migrations that are not applied yet, in order.
+func (dbAdapter Adapter) PendingMigrations() ([]Migration, error) {
+	schemaVersion, err := dbAdapter.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if schemaVersion > dbAdapter.LatestSchemaVersion() {
+		return nil, fmt.Errorf("%w (%d > %d)", ErrSchemaTooNew, schemaVersion, dbAdapter.LatestSchemaVersion())
+	}
+
+	var pending []Migration
+	for _, migration := range dbAdapter.migrations {
+		if migration.Version > schemaVersion {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Migrate applies the pending migrations, each in its own transaction, and returns the applied ones.
+func (dbAdapter Adapter) Migrate() ([]Migration, error) {
+	return dbAdapter.migrateTo(dbAdapter.LatestSchemaVersion())
+}
+
+// migrateTo applies the pending migrations up to a given schema version and returns the applied ones.
+func (dbAdapter Adapter) migrateTo(schemaVersion int) ([]Migration, error) {
+	pending, err := dbAdapter.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, migration := range pending {
+		if migration.Version > schemaVersion {
+			break
+		}
+
+		err = dbAdapter.applyMigration(migration)
+		if err != nil {
+			return applied, fmt.Errorf(
+				"migration %d (%s) failed: %w",
+				migration.Version,
+				migration.Description,
+				err,
+			)
+		}
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// applyMigration applies a single migration and bumps the schema version in the same transaction.
+func (dbAdapter Adapter) applyMigration(migration Migration) error {
+	tx, err := dbAdapter.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = migration.apply(tx)
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	// PRAGMA doesn't support parameters, the version is an int so it's safe to format it
+	_, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d;`, migration.Version))
+	if err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
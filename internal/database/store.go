@@ -0,0 +1,468 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/redds-be/rpkgm/internal/version"
+)
+
+// Location defines where the local database is, along with the repositories, in order of priority.
+type Location struct {
+	Local string
+	Repos []RepoLocation
+}
+
+// RepoLocation defines where a repository is fetched from and where its database is.
+// Source is the URL of the repository's files, {file} standing for the name of a file.
+type RepoLocation struct {
+	Name   string
+	Source string
+	Path   string
+}
+
+// Repo defines the database of a repository.
+type Repo struct {
+	Name string
+	*Adapter
+}
+
+// Store combines the local database, which holds what is installed, with the databases of the repositories, searched
+// in order of priority. Its methods accept package names qualified by a repository (ex: extra/foo).
+type Store struct {
+	*Adapter
+	Repos []Repo
+}
+
+// SplitName splits a package name qualified by a repository (ex: extra/foo), repo is empty if the name isn't qualified.
+func SplitName(name string) (string, string) {
+	repo, pkgName, isQualified := strings.Cut(name, "/")
+	if !isQualified {
+		return "", name
+	}
+
+	return repo, pkgName
+}
+
+// JoinName qualifies a package name by a repository, the name is returned as is if the repository is empty.
+func JoinName(repo, pkgName string) string {
+	if repo == "" {
+		return pkgName
+	}
+
+	return repo + "/" + pkgName
+}
+
+// FullName returns the package's name qualified by the repository its information comes from.
+func (info PkgInfo) FullName() string {
+	return JoinName(info.Repo, info.Name)
+}
+
+// legacyCacheDir is the cache directory build files dirs used to start with, relative to the root (ex:
+// var/rpkgm/main/foo).
+const legacyCacheDir = "var/rpkgm"
+
+// ErrNoBuildFiles is returned when a package has no build files dir (ex: a package installed from no repository).
+var ErrNoBuildFiles = errors.New("there is no build files dir")
+
+// BuildFilesPath returns where the package's build files are. Its build files dir is relative to the directory its
+// repository's files are extracted into, <cacheDir>/<repo>, unless it's absolute. ErrNoBuildFiles is returned if the
+// package has none, rather than the whole directory of its repository.
+func (info PkgInfo) BuildFilesPath(cacheDir string) (string, error) {
+	if filepath.IsAbs(info.BuildFilesDir) {
+		return info.BuildFilesDir, nil
+	}
+
+	// Build files dirs used to include the default cache directory and the repository
+	buildFilesDir := strings.TrimPrefix(info.BuildFilesDir+"/", legacyCacheDir+"/"+info.Repo+"/")
+	buildFilesDir = filepath.Clean(strings.Trim(buildFilesDir, "/"))
+
+	if info.Repo == "" || buildFilesDir == "." {
+		return "", fmt.Errorf("%w for %s", ErrNoBuildFiles, info.Name)
+	}
+
+	return filepath.Join(cacheDir, info.Repo, buildFilesDir), nil
+}
+
+// IsForeign checks if a package is installed but in no repository, it can't be updated nor reinstalled.
+func (info PkgInfo) IsForeign() bool {
+	return info.Installed && info.Repo == ""
+}
+
+// setInstalled fills the installed state of a package's information.
+func (info *PkgInfo) setInstalled(installed InstalledPkg) {
+	info.Installed = true
+	info.InstalledVersion = installed.Version
+	info.InstallReason = installed.Reason
+	info.InstalledRepo = installed.Repo
+	info.InstallDate = installed.Date
+}
+
+// installedOnly returns the information about an installed package that is in no repository.
+func installedOnly(installed InstalledPkg) PkgInfo {
+	info := PkgInfo{Name: installed.Name, Dependencies: installed.Dependencies}
+	info.setInstalled(installed)
+
+	return info
+}
+
+// Open opens the local database and the databases of the repositories that were synced, bringing their schemas up to
+// date. The local database is created first if it doesn't exist yet, see CreateLocal.
+func Open(location Location) (*Store, error) {
+	if _, err := os.Stat(location.Local); errors.Is(err, fs.ErrNotExist) {
+		err = CreateLocal(location)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	local, err := NewLocalAdapter("sqlite3", location.Local)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &Store{Adapter: local}
+
+	for _, repoLocation := range location.Repos {
+		// A repository that was never synced has no database yet
+		if _, err = os.Stat(repoLocation.Path); err != nil {
+			continue
+		}
+
+		repoAdapter, err := NewAdapter("sqlite3", repoLocation.Path)
+		if err != nil {
+			return nil, errors.Join(err, store.CloseDBConnection())
+		}
+
+		store.Repos = append(store.Repos, Repo{Name: repoLocation.Name, Adapter: repoAdapter})
+	}
+
+	return store, nil
+}
+
+// CreateLocal creates the local database along with what the repositories' databases say is installed, they used to
+// hold it before their schemas were migrated. It is built aside and moved in place once complete, so that an
+// interrupted creation is started over.
+func CreateLocal(location Location) error {
+	err := os.MkdirAll(filepath.Dir(location.Local), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := location.Local + ".new"
+
+	// Leftover of an interrupted creation
+	err = os.Remove(tmpPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	local, err := NewLocalAdapter("sqlite3", tmpPath)
+	if err != nil {
+		return err
+	}
+
+	for _, repoLocation := range location.Repos {
+		// A repository that was never synced has nothing installed
+		if _, err = os.Stat(repoLocation.Path); err != nil {
+			continue
+		}
+
+		// Its schema is only brought up to the last version that holds what is installed
+		repoAdapter, err := OpenAdapter("sqlite3", repoLocation.Path)
+		if err != nil {
+			return errors.Join(err, local.CloseDBConnection())
+		}
+
+		err = local.adopt(Repo{Name: repoLocation.Name, Adapter: repoAdapter})
+		if err = errors.Join(err, repoAdapter.CloseDBConnection()); err != nil {
+			return errors.Join(err, local.CloseDBConnection())
+		}
+	}
+
+	err = local.CloseDBConnection()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, location.Local)
+}
+
+// adopt records what a repository's database says is installed, the first repository to record a package wins.
+// Nothing is recorded if the repository's schema is already past legacySchemaVersion.
+func (dbAdapter Adapter) adopt(repo Repo) error {
+	schemaVersion, err := repo.SchemaVersion()
+	if err != nil || schemaVersion > legacySchemaVersion {
+		return err
+	}
+
+	_, err = repo.migrateTo(legacySchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	const queryString = `SELECT name, installedVersion, installReason, dependencies FROM packages WHERE installed = 1;`
+
+	rows, err := repo.dbase.Query(queryString) //nolint:sqlclosecheck
+	if err != nil {
+		return err
+	}
+
+	// Read every row before writing, the rows of a database have to be closed before it's used again
+	var adopted []InstalledPkg
+	for rows.Next() {
+		installed := InstalledPkg{Repo: repo.Name}
+		if err = rows.Scan(&installed.Name, &installed.Version, &installed.Reason, &installed.Dependencies); err != nil {
+			return errors.Join(err, rows.Close())
+		}
+		adopted = append(adopted, installed)
+	}
+
+	if err = rows.Close(); err != nil {
+		return err
+	}
+
+	return dbAdapter.WithTx(func(txAdapter *Adapter) error {
+		for _, installed := range adopted {
+			isInstalled, err := txAdapter.IsInstalled(installed.Name)
+			if err != nil {
+				return err
+			}
+
+			if isInstalled {
+				continue
+			}
+
+			// An invalid list can't be expressed as relations, the package just won't have any
+			if _, err = version.ParseConstraints(installed.Dependencies); err != nil {
+				installed.Dependencies = ""
+			}
+
+			manifest, err := repo.GetManifest(installed.Name)
+			if err != nil {
+				return err
+			}
+
+			err = txAdapter.SetInstalled(installed)
+			if err != nil {
+				return err
+			}
+
+			err = txAdapter.SetManifest(installed.Name, manifest)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// CloseDBConnection closes the connections to the local database and to the repositories' databases.
+func (store Store) CloseDBConnection() error {
+	errs := []error{store.Adapter.CloseDBConnection()}
+	for _, repo := range store.Repos {
+		errs = append(errs, repo.CloseDBConnection())
+	}
+
+	return errors.Join(errs...)
+}
+
+// lookupRepos returns the repositories a package is searched in along with its bare name. A qualified name is only
+// searched in its repository, nothing is returned if that repository doesn't exist.
+func (store Store) lookupRepos(name string) ([]Repo, string) {
+	repoName, pkgName := SplitName(name)
+	if repoName == "" {
+		return store.Repos, pkgName
+	}
+
+	for _, repo := range store.Repos {
+		if repo.Name == repoName {
+			return []Repo{repo}, pkgName
+		}
+	}
+
+	return nil, pkgName
+}
+
+// IsPkgInRepo searches the repositories for a package based on its name.
+func (store Store) IsPkgInRepo(name string) (bool, error) {
+	repos, pkgName := store.lookupRepos(name)
+	for _, repo := range repos {
+		isInRepo, err := repo.IsPkgInRepo(pkgName)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+
+		if isInRepo {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// GetPkgInfo returns the basic information about a given package, taken from the first repository that has it, along
+// with its installed state. An installed package that is in no repository only has its installed state.
+func (store Store) GetPkgInfo(name string) (PkgInfo, error) {
+	repos, pkgName := store.lookupRepos(name)
+
+	var info PkgInfo
+
+	for _, repo := range repos {
+		repoInfo, err := repo.GetPkgInfo(pkgName)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+
+		if err != nil {
+			return PkgInfo{}, err
+		}
+
+		info = repoInfo
+		info.Repo = repo.Name
+
+		break
+	}
+
+	installed, err := store.GetInstalled(pkgName)
+	if errors.Is(err, sql.ErrNoRows) && info.Repo != "" {
+		return info, nil
+	}
+
+	if err != nil {
+		return PkgInfo{}, err
+	}
+
+	// Asked for a given repository which doesn't have the package
+	if repoName, _ := SplitName(name); info.Repo == "" && repoName != "" {
+		return PkgInfo{}, sql.ErrNoRows
+	}
+
+	if info.Repo == "" {
+		return installedOnly(installed), nil
+	}
+
+	info.setInstalled(installed)
+
+	return info, nil
+}
+
+// GetAllPkgInfo returns the basic information about every package of every repository, in order of priority, followed
+// by the installed packages that are in no repository.
+func (store Store) GetAllPkgInfo() ([]PkgInfo, error) {
+	installedPkgs, err := store.GetAllInstalled()
+	if err != nil {
+		return nil, err
+	}
+
+	installedByName := make(map[string]InstalledPkg, len(installedPkgs))
+	for _, installed := range installedPkgs {
+		installedByName[installed.Name] = installed
+	}
+
+	var infos []PkgInfo
+
+	isInRepo := make(map[string]bool)
+
+	for _, repo := range store.Repos {
+		repoInfos, err := repo.GetAllPkgInfo()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, info := range repoInfos {
+			info.Repo = repo.Name
+			if installed, isInstalled := installedByName[info.Name]; isInstalled {
+				info.setInstalled(installed)
+			}
+
+			isInRepo[info.Name] = true
+			infos = append(infos, info)
+		}
+	}
+
+	for _, installed := range installedPkgs {
+		if !isInRepo[installed.Name] {
+			infos = append(infos, installedOnly(installed))
+		}
+	}
+
+	return infos, nil
+}
+
+// GetInstalledPkgInfo returns the basic information about every installed package.
+func (store Store) GetInstalledPkgInfo() ([]PkgInfo, error) {
+	installedPkgs, err := store.GetAllInstalled()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]PkgInfo, 0, len(installedPkgs))
+	for _, installed := range installedPkgs {
+		info, err := store.GetPkgInfo(installed.Name)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// IsInstalled checks if a given packages is already installed or not.
+func (store Store) IsInstalled(name string) (bool, error) {
+	_, pkgName := SplitName(name)
+
+	return store.Adapter.IsInstalled(pkgName)
+}
+
+// GetPkgBuildFilesDir returns where the build files of a given package are, taken from the first repository that has
+// it. See PkgInfo.BuildFilesPath.
+func (store Store) GetPkgBuildFilesDir(name, cacheDir string) (string, error) {
+	info, err := store.GetPkgInfo(name)
+	if err != nil {
+		return "", err
+	}
+
+	if info.Repo == "" {
+		return "", sql.ErrNoRows
+	}
+
+	return info.BuildFilesPath(cacheDir)
+}
+
+// FindRepo returns the first repository that has a given package along with the package's bare name,
+// sql.ErrNoRows is returned if no repository has it.
+func (store Store) FindRepo(name string) (Repo, string, error) {
+	repos, pkgName := store.lookupRepos(name)
+	for _, repo := range repos {
+		isInRepo, _ := repo.IsPkgInRepo(pkgName)
+		if isInRepo {
+			return repo, pkgName, nil
+		}
+	}
+
+	return Repo{}, pkgName, sql.ErrNoRows
+}
@@ -0,0 +1,56 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/redds-be/rpkgm/internal/database"
+)
+
+func TestBuildFilesPath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		repo, buildFilesDir string
+		want                string
+		wantErr             error
+	}{
+		{"main", "foo", "/var/cache/rpkgm/main/foo", nil},
+		{"main", "foo/", "/var/cache/rpkgm/main/foo", nil},
+		{"main", "var/rpkgm/main/foo", "/var/cache/rpkgm/main/foo", nil},
+		{"extra", "var/rpkgm/main/foo", "/var/cache/rpkgm/extra/var/rpkgm/main/foo", nil},
+		{"main", "/opt/foo", "/opt/foo", nil},
+		{"", "/opt/foo", "/opt/foo", nil},
+		{"main", "", "", database.ErrNoBuildFiles},
+		{"main", "var/rpkgm/main", "", database.ErrNoBuildFiles},
+		{"main", "var/rpkgm/main/", "", database.ErrNoBuildFiles},
+		{"", "", "", database.ErrNoBuildFiles},
+		{"", "foo", "", database.ErrNoBuildFiles},
+	}
+
+	for _, test := range tests {
+		info := database.PkgInfo{Name: "foo", Repo: test.repo, BuildFilesDir: test.buildFilesDir}
+
+		got, err := info.BuildFilesPath("/var/cache/rpkgm")
+		if got != test.want || !errors.Is(err, test.wantErr) {
+			t.Errorf("BuildFilesPath of %q from %q = %q, %v, want %q, %v",
+				test.buildFilesDir, test.repo, got, err, test.want, test.wantErr)
+		}
+	}
+}
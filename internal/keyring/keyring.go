@@ -0,0 +1,327 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Extensions of the files holding the keys, a single base64 line each, and the signatures.
+const (
+	PublicKeyExt  = ".pub"
+	PrivateKeyExt = ".key"
+	SignatureExt  = ".sig"
+)
+
+// signatureHeader is the first line of a signature file.
+const signatureHeader = "rpkgm signature"
+
+// Errors returned when a file can't be trusted.
+var (
+	ErrUnsigned           = errors.New("the file is not signed")
+	ErrMalformedSignature = errors.New("the signature is malformed")
+	ErrBadSignature       = errors.New("the signature does not match any of the repository's keys")
+	ErrNoRepoKeys         = errors.New("no key is bound to the repository, list the ones it's signed with in its section (keys = ...)")
+	ErrNoTrustedKeys      = errors.New("none of the repository's keys is trusted, add them with rpkgm key add")
+	ErrOtherFile          = errors.New("the signature is made for another file")
+	ErrRolledBack         = errors.New("the signature is older than the last one accepted for the file")
+)
+
+// ErrInvalidName is returned when a key's name can't be used as a file name.
+var ErrInvalidName = errors.New("invalid key name")
+
+// Key defines a trusted public key along with its name.
+type Key struct {
+	Name      string
+	PublicKey ed25519.PublicKey
+}
+
+// Fingerprint returns a short hex digest of the key, to compare it with the one the repository's maintainer published.
+func (key Key) Fingerprint() string {
+	digest := sha256.Sum256(key.PublicKey)
+
+	return hex.EncodeToString(digest[:8])
+}
+
+// Keyring defines a directory of trusted public keys, one <name>.pub file per key.
+type Keyring struct {
+	Dir string
+}
+
+// checkName makes sure a key's name can be used as a file name.
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w %q", ErrInvalidName, name)
+	}
+
+	return nil
+}
+
+// readBase64 reads a file holding a single base64 line of a given length once decoded.
+func readBase64(path string, size int) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid base64: %w", path, err)
+	}
+
+	if len(decoded) != size {
+		return nil, fmt.Errorf("%s holds %d bytes instead of %d", path, len(decoded), size) //nolint:goerr113
+	}
+
+	return decoded, nil
+}
+
+// writeBase64 writes a value to a file as a single base64 line.
+func writeBase64(path string, value []byte, perm os.FileMode) error {
+	return os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(value)+"\n"), perm)
+}
+
+// ReadPublicKey reads a public key file.
+func ReadPublicKey(path string) (ed25519.PublicKey, error) {
+	return readBase64(path, ed25519.PublicKeySize)
+}
+
+// Add trusts a public key under a given name, replacing the key that had that name.
+func (keyring Keyring) Add(name string, publicKey ed25519.PublicKey) error {
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(keyring.Dir, 0o755) //nolint:gomnd
+	if err != nil {
+		return err
+	}
+
+	return writeBase64(filepath.Join(keyring.Dir, name+PublicKeyExt), publicKey, 0o644) //nolint:gomnd
+}
+
+// Remove stops trusting the key of a given name.
+func (keyring Keyring) Remove(name string) error {
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(filepath.Join(keyring.Dir, name+PublicKeyExt))
+}
+
+// Keys returns the trusted keys, sorted by name. A keyring whose directory doesn't exist has no keys.
+func (keyring Keyring) Keys() ([]Key, error) {
+	entries, err := os.ReadDir(keyring.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+
+	for _, entry := range entries {
+		name, isKey := strings.CutSuffix(entry.Name(), PublicKeyExt)
+		if !isKey || entry.IsDir() {
+			continue
+		}
+
+		publicKey, err := ReadPublicKey(filepath.Join(keyring.Dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, Key{Name: name, PublicKey: publicKey})
+	}
+
+	return keys, nil
+}
+
+// Signature defines the detached signature of a repository's file (<file>.sig). It's made for the name of the file
+// and its repository along with a serial, the time it was signed at by default (unix seconds), so that it can neither
+// be used for another file nor replayed once a newer one was accepted.
+type Signature struct {
+	Repo   string
+	File   string
+	Serial int64
+	Value  []byte
+}
+
+// header returns the lines of the signature file that come before its value, they are signed along with the file.
+func (sig Signature) header() string {
+	return fmt.Sprintf("%s\nrepo %s\nfile %s\nserial %d\n", signatureHeader, sig.Repo, sig.File, sig.Serial)
+}
+
+// String returns the content of the signature file.
+func (sig Signature) String() string {
+	return sig.header() + "value " + base64.StdEncoding.EncodeToString(sig.Value) + "\n"
+}
+
+// readSignature reads a signature file.
+func readSignature(path string) (Signature, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Signature{}, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if lines[0] != signatureHeader {
+		return Signature{}, fmt.Errorf("%w: %s doesn't start with %q, sign the file again", ErrMalformedSignature, path, signatureHeader)
+	}
+
+	fields := make(map[string]string, len(lines)-1)
+	for _, line := range lines[1:] {
+		key, value, _ := strings.Cut(line, " ")
+		fields[key] = value
+	}
+
+	sig := Signature{Repo: fields["repo"], File: fields["file"]}
+
+	sig.Serial, err = strconv.ParseInt(fields["serial"], 10, 64)
+	if err == nil {
+		sig.Value, err = base64.StdEncoding.DecodeString(fields["value"])
+	}
+
+	if err != nil || sig.Repo == "" || sig.File == "" || len(sig.Value) != ed25519.SignatureSize {
+		return Signature{}, fmt.Errorf("%w: %s", ErrMalformedSignature, path)
+	}
+
+	return sig, nil
+}
+
+// Trust defines what the signature of a file has to be made for to be trusted: its repository, by one of the keys
+// bound to it, with a serial no older than the last one accepted for the file (0 if none was).
+type Trust struct {
+	Repo      string
+	Keys      []string
+	MinSerial int64
+}
+
+// Verify makes sure a file is signed for its name and the trusted repository, by one of the repository's keys and
+// with a recent enough serial, using its detached signature (<file>.sig). The key and the signature are returned.
+func (keyring Keyring) Verify(path string, trust Trust) (Key, Signature, error) {
+	if len(trust.Keys) == 0 {
+		return Key{}, Signature{}, ErrNoRepoKeys
+	}
+
+	sig, err := readSignature(path + SignatureExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Key{}, Signature{}, ErrUnsigned
+	}
+
+	if err != nil {
+		return Key{}, Signature{}, err
+	}
+
+	if sig.Repo != trust.Repo || sig.File != filepath.Base(path) {
+		return Key{}, Signature{}, fmt.Errorf("%w: %s of the repository %s", ErrOtherFile, sig.File, sig.Repo)
+	}
+
+	keys, err := keyring.Keys()
+	if err != nil {
+		return Key{}, Signature{}, err
+	}
+
+	keys = slices.DeleteFunc(keys, func(key Key) bool { return !slices.Contains(trust.Keys, key.Name) })
+	if len(keys) == 0 {
+		return Key{}, Signature{}, ErrNoTrustedKeys
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, Signature{}, err
+	}
+
+	signed := append([]byte(sig.header()), content...)
+
+	for _, key := range keys {
+		if !ed25519.Verify(key.PublicKey, signed, sig.Value) {
+			continue
+		}
+
+		// The file was signed before the one accepted last time (ex: a mirror serving an old, vulnerable version)
+		if sig.Serial < trust.MinSerial {
+			return Key{}, Signature{}, fmt.Errorf("%w (%d < %d)", ErrRolledBack, sig.Serial, trust.MinSerial)
+		}
+
+		return key, sig, nil
+	}
+
+	return Key{}, Signature{}, ErrBadSignature
+}
+
+// Generate creates a key pair for a repository's maintainer, <prefix>.key holds the private key and <prefix>.pub the
+// public one, which is the one users add to their keyring.
+func Generate(prefix string) (Key, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return Key{}, err
+	}
+
+	// The private key is only readable by its owner and is never overwritten
+	file, err := os.OpenFile(prefix+PrivateKeyExt, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) //nolint:gomnd
+	if err != nil {
+		return Key{}, err
+	}
+
+	_, err = file.WriteString(base64.StdEncoding.EncodeToString(privateKey) + "\n")
+	if err = errors.Join(err, file.Close()); err != nil {
+		return Key{}, err
+	}
+
+	err = writeBase64(prefix+PublicKeyExt, publicKey, 0o644) //nolint:gomnd
+	if err != nil {
+		return Key{}, err
+	}
+
+	return Key{Name: filepath.Base(prefix), PublicKey: publicKey}, nil
+}
+
+// Sign writes the detached signature of a repository's file (<file>.sig) using a private key file. The next versions
+// of the file have to be signed with a serial at least as great, see Signature.
+func Sign(privateKeyPath, path, repo string, serial int64) error {
+	privateKey, err := readBase64(privateKeyPath, ed25519.PrivateKeySize)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	sig := Signature{Repo: repo, File: filepath.Base(path), Serial: serial}
+	sig.Value = ed25519.Sign(privateKey, append([]byte(sig.header()), content...))
+
+	return os.WriteFile(path+SignatureExt, []byte(sig.String()), 0o644) //nolint:gomnd
+}
@@ -0,0 +1,180 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package keyring_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/redds-be/rpkgm/internal/keyring"
+)
+
+// signedFile creates a keyring trusting the keys redd and other, then writes repo.json signed by signer for repo with
+// serial and returns the keyring along with the file's path.
+func signedFile(t *testing.T, signer, repo string, serial int64) (keyring.Keyring, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	trusted := keyring.Keyring{Dir: filepath.Join(dir, "keys")}
+
+	for _, name := range []string{"redd", "other"} {
+		key, err := keyring.Generate(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Generate(%s) error: %s", name, err)
+		}
+
+		err = trusted.Add(name, key.PublicKey)
+		if err != nil {
+			t.Fatalf("Add(%s) error: %s", name, err)
+		}
+	}
+
+	path := filepath.Join(dir, "repo.json")
+
+	err := os.WriteFile(path, []byte(`{"packages": []}`), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile error: %s", err)
+	}
+
+	err = keyring.Sign(filepath.Join(dir, signer+keyring.PrivateKeyExt), path, repo, serial)
+	if err != nil {
+		t.Fatalf("Sign error: %s", err)
+	}
+
+	return trusted, path
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		signer  string
+		trust   keyring.Trust
+		change  func(path string) (string, error)
+		wantErr error
+	}{
+		{
+			name:   "signed by the repository's key",
+			signer: "redd",
+			trust:  keyring.Trust{Repo: "main", Keys: []string{"redd"}, MinSerial: 5},
+		},
+		{
+			name:   "same serial as the last one accepted",
+			signer: "redd",
+			trust:  keyring.Trust{Repo: "main", Keys: []string{"redd"}, MinSerial: 10},
+		},
+		{
+			name:    "older serial than the last one accepted",
+			signer:  "redd",
+			trust:   keyring.Trust{Repo: "main", Keys: []string{"redd"}, MinSerial: 11},
+			wantErr: keyring.ErrRolledBack,
+		},
+		{
+			name:    "signed for another repository",
+			signer:  "redd",
+			trust:   keyring.Trust{Repo: "extra", Keys: []string{"redd"}},
+			wantErr: keyring.ErrOtherFile,
+		},
+		{
+			name:   "signed for another file",
+			signer: "redd",
+			trust:  keyring.Trust{Repo: "main", Keys: []string{"redd"}},
+			change: func(path string) (string, error) {
+				other := filepath.Join(filepath.Dir(path), "other.json")
+
+				return other, errors.Join(os.Rename(path, other), os.Rename(path+keyring.SignatureExt, other+keyring.SignatureExt))
+			},
+			wantErr: keyring.ErrOtherFile,
+		},
+		{
+			name:    "signed by a trusted key not bound to the repository",
+			signer:  "other",
+			trust:   keyring.Trust{Repo: "main", Keys: []string{"redd"}},
+			wantErr: keyring.ErrBadSignature,
+		},
+		{
+			name:    "repository's key not trusted",
+			signer:  "redd",
+			trust:   keyring.Trust{Repo: "main", Keys: []string{"missing"}},
+			wantErr: keyring.ErrNoTrustedKeys,
+		},
+		{
+			name:    "no key bound to the repository",
+			signer:  "redd",
+			trust:   keyring.Trust{Repo: "main"},
+			wantErr: keyring.ErrNoRepoKeys,
+		},
+		{
+			name:    "unsigned",
+			signer:  "redd",
+			trust:   keyring.Trust{Repo: "main", Keys: []string{"redd"}},
+			change:  func(path string) (string, error) { return path, os.Remove(path + keyring.SignatureExt) },
+			wantErr: keyring.ErrUnsigned,
+		},
+		{
+			name:   "signature without a header",
+			signer: "redd",
+			trust:  keyring.Trust{Repo: "main", Keys: []string{"redd"}},
+			change: func(path string) (string, error) {
+				return path, os.WriteFile(path+keyring.SignatureExt, []byte("c2lnbmF0dXJl\n"), 0o600)
+			},
+			wantErr: keyring.ErrMalformedSignature,
+		},
+		{
+			name:   "tampered file",
+			signer: "redd",
+			trust:  keyring.Trust{Repo: "main", Keys: []string{"redd"}},
+			change: func(path string) (string, error) {
+				return path, os.WriteFile(path, []byte(`{"packages": [{"name": "evil"}]}`), 0o600)
+			},
+			wantErr: keyring.ErrBadSignature,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			trusted, path := signedFile(t, test.signer, "main", 10)
+
+			if test.change != nil {
+				var err error
+
+				path, err = test.change(path)
+				if err != nil {
+					t.Fatalf("changing the file error: %s", err)
+				}
+			}
+
+			key, sig, err := trusted.Verify(path, test.trust)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, test.wantErr)
+			}
+
+			if test.wantErr != nil {
+				return
+			}
+
+			if key.Name != test.signer || sig.Serial != 10 {
+				t.Errorf("Verify() = %s, serial %d, want %s, serial 10", key.Name, sig.Serial, test.signer)
+			}
+		})
+	}
+}
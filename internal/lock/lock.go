@@ -0,0 +1,156 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FileName is the name of the lock file in the state directory.
+const FileName = "rpkgm.lock"
+
+// pollInterval is how often a busy lock is retried while waiting for it.
+const pollInterval = 200 * time.Millisecond
+
+// ErrLocked is returned when the lock is held by another process.
+var ErrLocked = errors.New("rpkgm is locked")
+
+// Lock defines the exclusive lock of a state directory, it is held until released or until the process exits.
+type Lock struct {
+	file *os.File
+	// StalePID is the PID a previous holder left in the lock file without releasing it (0 if there was none).
+	StalePID int
+}
+
+// Acquire takes the exclusive lock of a state directory. If another process holds it, ErrLocked is returned
+// along with its PID, unless wait is set, in which case Acquire waits for the lock (forever if timeout is 0).
+// onWait is called once, with the holder's PID, when Acquire starts waiting. The state directory is created if it
+// doesn't exist yet (ex: on a fresh system or root).
+func Acquire(stateDir string, wait bool, timeout time.Duration, onWait func(pid int)) (*Lock, error) {
+	// Every rpkgm has to take the same lock, wherever it's run from
+	stateDir, err := filepath.Abs(stateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(stateDir, 0o755) //nolint:gomnd
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(stateDir, FileName), os.O_RDWR|os.O_CREATE, 0o644) //nolint:gomnd
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	hasWaited := false
+
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errors.Join(err, file.Close())
+		}
+
+		pid := readPID(file)
+
+		if !wait || (timeout > 0 && time.Now().After(deadline)) {
+			return nil, errors.Join(fmt.Errorf("%w by PID %d", ErrLocked, pid), file.Close())
+		}
+
+		if !hasWaited && onWait != nil {
+			onWait(pid)
+		}
+		hasWaited = true
+
+		time.Sleep(pollInterval)
+	}
+
+	lock := &Lock{file: file}
+
+	// A PID left in the file belongs to a holder that exited without releasing the lock (the lock itself is released
+	// by the kernel when its holder exits)
+	if pid := readPID(file); pid != 0 && pid != os.Getpid() && !isRunning(pid) {
+		lock.StalePID = pid
+	}
+
+	// Record who holds the lock
+	err = lock.writePID(strconv.Itoa(os.Getpid()) + "\n")
+	if err != nil {
+		return nil, errors.Join(err, file.Close())
+	}
+
+	return lock, nil
+}
+
+// Release clears the lock file and releases the lock.
+func (lock *Lock) Release() error {
+	err := lock.writePID("")
+	if err != nil {
+		return errors.Join(err, lock.file.Close())
+	}
+
+	// Closing the file releases the lock
+	return lock.file.Close()
+}
+
+// writePID replaces the content of the lock file.
+func (lock *Lock) writePID(content string) error {
+	err := lock.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = lock.file.WriteAt([]byte(content), 0)
+
+	return err
+}
+
+// readPID returns the PID written in the lock file, 0 if there is none.
+func readPID(file *os.File) int {
+	content, err := io.ReadAll(io.NewSectionReader(file, 0, 32)) //nolint:gomnd
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0
+	}
+
+	return pid
+}
+
+// isRunning reports whether a process with the given PID exists.
+func isRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+
+	// EPERM means the process exists but belongs to someone else
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
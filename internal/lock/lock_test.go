@@ -0,0 +1,144 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lock_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/redds-be/rpkgm/internal/lock"
+)
+
+// exitedPID returns the PID of a process that already exited.
+func exitedPID(t *testing.T) int {
+	t.Helper()
+
+	cmd := exec.Command("true")
+
+	err := cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestAcquireStalePID(t *testing.T) {
+	t.Parallel()
+
+	exited := exitedPID(t)
+
+	tests := []struct {
+		name    string
+		noFile  bool
+		content string
+		want    int
+	}{
+		{name: "no lock file", noFile: true},
+		{name: "released lock", content: ""},
+		{name: "exited holder", content: strconv.Itoa(exited) + "\n", want: exited},
+		{name: "running process", content: strconv.Itoa(os.Getppid()) + "\n"},
+		{name: "own PID", content: strconv.Itoa(os.Getpid()) + "\n"},
+		{name: "garbage", content: "rpkgm\n"},
+	}
+
+	for _, test := range tests {
+		stateDir := t.TempDir()
+
+		if !test.noFile {
+			err := os.WriteFile(filepath.Join(stateDir, lock.FileName), []byte(test.content), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stateLock, err := lock.Acquire(stateDir, false, 0, nil)
+		if err != nil {
+			t.Errorf("%s: Acquire returned an error: %s", test.name, err)
+
+			continue
+		}
+
+		if stateLock.StalePID != test.want {
+			t.Errorf("%s: StalePID is %d, want %d", test.name, stateLock.StalePID, test.want)
+		}
+
+		err = stateLock.Release()
+		if err != nil {
+			t.Errorf("%s: Release returned an error: %s", test.name, err)
+		}
+
+		// The lock file is cleared on release, the next holder finds nothing stale
+		content, err := os.ReadFile(filepath.Join(stateDir, lock.FileName))
+		if err != nil || len(content) != 0 {
+			t.Errorf("%s: the lock file holds %q after Release (error: %v)", test.name, content, err)
+		}
+	}
+}
+
+func TestAcquireHeld(t *testing.T) {
+	t.Parallel()
+
+	// The state directory is created if it doesn't exist yet
+	stateDir := filepath.Join(t.TempDir(), "var", "lib", "rpkgm")
+
+	held, err := lock.Acquire(stateDir, false, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A lock taken through another open file conflicts, even from the same process
+	_, err = lock.Acquire(stateDir, false, 0, nil)
+	if !errors.Is(err, lock.ErrLocked) {
+		t.Errorf("Acquire on a held lock returned %v, want %v", err, lock.ErrLocked)
+	}
+
+	waitedFor := 0
+
+	_, err = lock.Acquire(stateDir, true, 300*time.Millisecond, func(pid int) { waitedFor = pid })
+	if !errors.Is(err, lock.ErrLocked) {
+		t.Errorf("Acquire waiting on a held lock returned %v, want %v", err, lock.ErrLocked)
+	}
+
+	if waitedFor != os.Getpid() {
+		t.Errorf("Acquire waited for PID %d, want %d", waitedFor, os.Getpid())
+	}
+
+	err = held.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateLock, err := lock.Acquire(stateDir, false, 0, nil)
+	if err != nil {
+		t.Fatalf("Acquire on a released lock returned an error: %s", err)
+	}
+
+	if stateLock.StalePID != 0 {
+		t.Errorf("StalePID is %d after a release, want 0", stateLock.StalePID)
+	}
+
+	err = stateLock.Release()
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -4,15 +4,27 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 )
 
+// FilePath is the path of the log file.
+var FilePath = "var/log/rpkgm.log"
+
 // LogToFile logs given content to a log file.
 func LogToFile(format string, toLog ...any) {
 	// File mode to use
 	const fileMode = 0o666
 
+	// Create the log file's directory, it may not exist yet in a new root
+	err := os.MkdirAll(filepath.Dir(FilePath), os.ModePerm)
+	if err != nil {
+		log.Printf("rpkgm could not create the directory of the log file. Error: %s\n", err)
+
+		return
+	}
+
 	// Open the log file or create it if it does not exist
-	logFile, err := os.OpenFile("var/log/rpkgm.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, fileMode)
+	logFile, err := os.OpenFile(FilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, fileMode)
 	if err != nil {
 		log.Printf("rpkgm could not open the log file. Error: %s\n", err)
 
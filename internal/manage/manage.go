@@ -1,15 +1,19 @@
 package manage
 
 import (
+	"database/sql"
+	"errors"
 	"os"
+	"time"
 
 	"github.com/redds-be/rpkgm/internal/database"
 	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/redds-be/rpkgm/internal/version"
 )
 
-// remove removes a package.
-func remove(name string, dbAdapter *database.Adapter) {
-	err := dbAdapter.RemovePackage(name)
+// remove removes a package from its repository, whether it's installed or not is left as it is.
+func remove(name string, repo database.Repo, store *database.Store) {
+	err := repo.RemovePackage(name)
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -17,11 +21,11 @@ func remove(name string, dbAdapter *database.Adapter) {
 			"rpkgm could not delete the package from the repository. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Close the database connection
-	err = dbAdapter.CloseDBConnection()
+	err = store.CloseDBConnection()
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -29,10 +33,10 @@ func remove(name string, dbAdapter *database.Adapter) {
 			"rpkgm could not close the connection to the database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
-	os.Exit(0)
+	util.Exit(0)
 }
 
 // changeDesc changes the description of a package.
@@ -45,35 +49,71 @@ func changeDesc(name, newDesc string, dbAdapter *database.Adapter) {
 			"rpkgm could not change the package's description in the repo's database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
-// markAsInstalled marks a package as installed.
-func markAsInstalled(name string, dbAdapter *database.Adapter) {
-	err := dbAdapter.MarkAsInstalled(name)
+// markAsInstalled marks a package as explicitly installed from its repository, at the repository's version.
+// A package that is already installed is left as it is.
+func markAsInstalled(name string, repo database.Repo, dbAdapter *database.Adapter) {
+	isInstalled, err := dbAdapter.IsInstalled(name)
+	if err == nil && isInstalled {
+		return
+	}
+
+	pkgInfo, err := repo.GetPkgInfo(name)
+	if err == nil {
+		err = dbAdapter.SetInstalled(database.InstalledPkg{
+			Name:         name,
+			Version:      pkgInfo.RepoVersion,
+			Reason:       database.ReasonExplicit,
+			Repo:         repo.Name,
+			Dependencies: pkgInfo.Dependencies,
+			Date:         time.Now().Format(time.RFC3339),
+		})
+	}
+
 	if err != nil {
 		util.Display(
 			os.Stderr,
 			true,
-			"rpkgm could not mark the package as installed in the repo's database. Error: %s",
+			"rpkgm could not mark the package as installed in the local database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
 // markAsNotInstalled marks a package as not installed.
 func markAsNotInstalled(name string, dbAdapter *database.Adapter) {
-	err := dbAdapter.MarkAsNotInstalled(name)
+	err := dbAdapter.RemoveInstalled(name)
+	if err != nil {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not mark the package as not installed in the local database. Error: %s",
+			err,
+		)
+		util.Exit(1)
+	}
+}
+
+// setInstallReason changes the reason a package is installed for.
+func setInstallReason(name, reason string, dbAdapter *database.Adapter) {
+	err := dbAdapter.SetInstallReason(name, reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		util.Display(os.Stderr, true, "The package %s is not installed.", name)
+		util.Exit(1)
+	}
+
 	if err != nil {
 		util.Display(
 			os.Stderr,
 			true,
-			"rpkgm could not mark the package as not installed in the repo's database. Error: %s",
+			"rpkgm could not change the package's install reason in the local database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
@@ -87,7 +127,7 @@ func changeInstalledVersion(name, installedVersion string, dbAdapter *database.A
 			"rpkgm could not set the package's installed version. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
@@ -101,7 +141,7 @@ func changeRepoVersion(name, repoVersion string, dbAdapter *database.Adapter) {
 			"rpkgm could not set the package's repo version. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
@@ -115,7 +155,7 @@ func changeArchiveURL(name, achiveURL string, dbAdapter *database.Adapter) {
 			"rpkgm could not change the package's archive's URL. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
@@ -129,13 +169,25 @@ func changeArchiveHash(name, hash string, dbAdapter *database.Adapter) {
 			"rpkgm could not change the package's archive's hash. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
 // changePkgDeps changes the package's deps.
 func changePkgDeps(name, deps string, dbAdapter *database.Adapter) {
-	err := dbAdapter.ChangeDeps(name, deps)
+	// Make sure the dependencies list is valid (ex: zlib>=1.2.13 openssl<3 foo=1.4.*)
+	_, err := version.ParseConstraints(deps)
+	if err != nil {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not change the package's dependencies list, it is invalid. Error: %s",
+			err,
+		)
+		util.Exit(1)
+	}
+
+	err = dbAdapter.ChangeDeps(name, deps)
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -143,7 +195,7 @@ func changePkgDeps(name, deps string, dbAdapter *database.Adapter) {
 			"rpkgm could not change the package's dependencies list. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
@@ -157,29 +209,42 @@ func rename(name, newName string, dbAdapter *database.Adapter) {
 			"rpkgm could not rename the package in the repo's database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
-// Decide decides what to do based on the given booleans.
+// Decide decides what to do based on the given booleans. The package is managed in the first repository that has it,
+// name can be qualified by a repository to pick another one (ex: extra/foo).
 func Decide( //nolint:funlen,cyclop
-	repoDB, name, newName, newDesc, installedVersion, repoVersion, archiveURL, hash, deps string,
-	doRemove, markInstalled, markNotInstalled bool,
+	dbLocation database.Location,
+	name, newName, newDesc, installedVersion, repoVersion, archiveURL, hash, deps string,
+	doRemove, markInstalled, markNotInstalled, markExplicit, markAsDeps bool,
 ) {
-	// Connect to the database
-	dbAdapter, err := database.NewAdapter("sqlite3", repoDB)
+	// Connect to the databases
+	store, err := database.Open(dbLocation)
 	if err != nil {
 		util.Display(os.Stderr, true, "rpkgm could not connect to the database. Error: %s", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
-	// Check if the given package is in the repo (forcing the close of the db connection since it's not a fatal error)
-	isInRepo, _ := dbAdapter.IsPkgInRepo(name)
-	if !isInRepo {
-		util.Display(os.Stderr, true, "The package: %s is not in the repository.")
+	// Changing why a package is installed only changes the local database, a package that is in no repository (foreign)
+	// can have it changed too
+	isLocalOnly := !doRemove && newDesc == "" && !markInstalled && !markNotInstalled && installedVersion == "" &&
+		repoVersion == "" && archiveURL == "" && hash == "" && deps == "" && newName == ""
+
+	// Find the repository the given package is in (forcing the close of the db connection since it's not a fatal error)
+	var repo database.Repo
+	if isLocalOnly {
+		_, name = database.SplitName(name)
+	} else {
+		repo, name, err = store.FindRepo(name)
+	}
+
+	if err != nil {
+		util.Display(os.Stderr, true, "The package: %s is not in the repository.", name)
 
 		// Close the database connection
-		err := dbAdapter.CloseDBConnection()
+		err := store.CloseDBConnection()
 		if err != nil {
 			util.Display(
 				os.Stderr,
@@ -187,60 +252,67 @@ func Decide( //nolint:funlen,cyclop
 				"rpkgm could not close the connection to the database. Error: %s",
 				err,
 			)
-			os.Exit(1)
+			util.Exit(1)
 		}
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Remove the package
 	if doRemove {
-		remove(name, dbAdapter)
+		remove(name, repo, store)
 	}
 
 	// Change the package's description
 	if newDesc != "" {
-		changeDesc(name, newDesc, dbAdapter)
+		changeDesc(name, newDesc, repo.Adapter)
 	}
 
 	// Mark the package as installed or as not installed
 	if markInstalled {
-		markAsInstalled(name, dbAdapter)
+		markAsInstalled(name, repo, store.Adapter)
 	} else if markNotInstalled {
-		markAsNotInstalled(name, dbAdapter)
+		markAsNotInstalled(name, store.Adapter)
+	}
+
+	// Mark the package as explicitly installed or as installed as a dependency
+	if markExplicit {
+		setInstallReason(name, database.ReasonExplicit, store.Adapter)
+	} else if markAsDeps {
+		setInstallReason(name, database.ReasonDependency, store.Adapter)
 	}
 
 	// Change or set the package's installed version
 	if installedVersion != "" {
-		changeInstalledVersion(name, installedVersion, dbAdapter)
+		changeInstalledVersion(name, installedVersion, store.Adapter)
 	}
 
 	// Change the package's repo version
 	if repoVersion != "" {
-		changeRepoVersion(name, repoVersion, dbAdapter)
+		changeRepoVersion(name, repoVersion, repo.Adapter)
 	}
 
 	// Change the package's archive's URL
 	if archiveURL != "" {
-		changeArchiveURL(name, archiveURL, dbAdapter)
+		changeArchiveURL(name, archiveURL, repo.Adapter)
 	}
 
 	// Change the package's archive's hash
 	if hash != "" {
-		changeArchiveHash(name, hash, dbAdapter)
+		changeArchiveHash(name, hash, repo.Adapter)
 	}
 
 	// Change the package's dependencies list
 	if deps != "" {
-		changePkgDeps(name, deps, dbAdapter)
+		changePkgDeps(name, deps, repo.Adapter)
 	}
 
 	// Rename the package
 	if newName != "" {
-		rename(name, newName, dbAdapter)
+		rename(name, newName, repo.Adapter)
 	}
 
 	// Close the database connection
-	err = dbAdapter.CloseDBConnection()
+	err = store.CloseDBConnection()
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -248,6 +320,6 @@ func Decide( //nolint:funlen,cyclop
 			"rpkgm could not close the connection to the database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
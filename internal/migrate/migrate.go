@@ -0,0 +1,143 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package migrate
+
+import (
+	"os"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/util"
+)
+
+// migrateDB shows the schema version of a database and applies its pending migrations, or only shows them.
+func migrateDB(label string, dbAdapter *database.Adapter, dryRun bool) { //nolint:funlen
+	// Get the current schema version
+	schemaVersion, err := dbAdapter.SchemaVersion()
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not get the %s's schema version. Error: %s", label, err)
+		util.Exit(1)
+	}
+
+	util.Display(
+		os.Stdout,
+		false,
+		"Schema version of the %s: %d (latest: %d)",
+		label,
+		schemaVersion,
+		dbAdapter.LatestSchemaVersion(),
+	)
+
+	// Get the migrations to apply
+	pending, err := dbAdapter.PendingMigrations()
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not get the pending migrations of the %s. Error: %s", label, err)
+		util.Exit(1)
+	}
+
+	if len(pending) == 0 {
+		util.Display(os.Stdout, false, "The %s is up to date.", label)
+	}
+
+	// Only show what would be done
+	if dryRun {
+		for _, migration := range pending {
+			util.Display(
+				os.Stdout,
+				false,
+				"Would apply migration %d: %s",
+				migration.Version,
+				migration.Description,
+			)
+		}
+	} else if len(pending) > 0 {
+		applied, err := dbAdapter.Migrate()
+		for _, migration := range applied {
+			util.Display(
+				os.Stdout,
+				true,
+				"Applied migration %d to the %s: %s",
+				migration.Version,
+				label,
+				migration.Description,
+			)
+		}
+
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm could not migrate the %s. Error: %s", label, err)
+			util.Exit(1)
+		}
+	}
+
+	// Close the database connection
+	err = dbAdapter.CloseDBConnection()
+	if err != nil {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not close the connection to the database. Error: %s",
+			err,
+		)
+		util.Exit(1)
+	}
+}
+
+// Decide decides what to do based on the given booleans.
+func Decide(dbLocation database.Location, dryRun bool) {
+	// The local database has to hold what the repositories' databases say is installed before their schemas drop it
+	if _, err := os.Stat(dbLocation.Local); err != nil && dryRun {
+		util.Display(
+			os.Stdout,
+			false,
+			"Would create the local database with what the repositories' databases say is installed.",
+		)
+	} else {
+		if err != nil {
+			util.Display(os.Stdout, true, "Creating the local database with what the repositories' databases say is installed...")
+
+			err = database.CreateLocal(dbLocation)
+			if err != nil {
+				util.Display(os.Stderr, true, "rpkgm could not create the local database. Error: %s", err)
+				util.Exit(1)
+			}
+		}
+
+		// Connect to the database without migrating it
+		dbAdapter, err := database.OpenLocalAdapter("sqlite3", dbLocation.Local)
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm could not connect to the local database. Error: %s", err)
+			util.Exit(1)
+		}
+
+		migrateDB("local database", dbAdapter, dryRun)
+	}
+
+	for _, repo := range dbLocation.Repos {
+		// A repository that was never synced has no database yet
+		if _, err := os.Stat(repo.Path); err != nil {
+			continue
+		}
+
+		// Connect to the database without migrating it
+		dbAdapter, err := database.OpenAdapter("sqlite3", repo.Path)
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm could not connect to the database of %s. Error: %s", repo.Name, err)
+			util.Exit(1)
+		}
+
+		migrateDB(repo.Name+" repository's database", dbAdapter, dryRun)
+	}
+}
@@ -6,85 +6,36 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/solver"
+	"github.com/redds-be/rpkgm/internal/stage"
+	"github.com/redds-be/rpkgm/internal/transaction"
 	"github.com/redds-be/rpkgm/internal/util"
 )
 
 // MarkedPkgs is a slice that contains the name of the packages marked for an operation.
 var MarkedPkgs []string
 
-// resolveDeps recursively resolves the dependencies of a slice of dependencies and marks them for installation.
-func resolveDeps(mainPkgName string, deps []string, dbAdapter *database.Adapter) { //nolint:funlen
-	for _, pkgName := range deps {
-		// Since sometimes the deps list contains a single empty string (due to using split on an empty string)
-		// we just ignore it
-		if pkgName == "" {
-			continue
-		}
-
-		// Check if the dependency is in the repo
-		isInRepo, _ := dbAdapter.IsPkgInRepo(pkgName)
-		if !isInRepo {
-			util.Display(
-				os.Stderr,
-				true,
-				"%s's dependency named %s is not in the repository, you will need to install it yourself...",
-				mainPkgName,
-				pkgName,
-			)
-
-			continue
-		}
-
-		// Get the information for the package
-		pkgInfo, err := dbAdapter.GetPkgInfo(pkgName)
-		if err != nil {
-			util.Display(
-				os.Stderr,
-				true,
-				"rpkgm couldn't get %s's dependency %s's information despite it being in the repo, you will need to install it yourself...",
-				mainPkgName,
-				pkgName,
-			)
-
-			continue
-		}
-
-		// Check if the dependency is already installed
-		isInstalled, err := dbAdapter.IsInstalled(pkgName)
-		if err != nil {
-			util.Display(
-				os.Stderr,
-				true,
-				"rpkgm couldn't determine if %s's dependency %s is already installed, you will need to install it yourself...",
-				mainPkgName,
-				pkgName,
-			)
+// Directories the packages are built in, and kept in when asked to, along with extra arguments given to make.
+var (
+	BuildDir = "/tmp/rpkgm"
+	SrcDir   = "/tmp/usr/src/rpkgm"
+	MakeArgs string
+)
 
-			continue
-		}
+// Root is the root the packages are installed into.
+var Root = "/"
 
-		// Check if the dependency is a duplicate
-		isDuplicate := slices.Contains(MarkedPkgs, pkgName)
-
-		// If the dependency is in the repo, is not already installed and is not a duplicate,
-		// resolve its dependencies and mark de dependency for installation
-		if isInRepo && !isInstalled && !isDuplicate {
-			moreDeps := strings.Split(pkgInfo.Dependencies, " ")
-			if len(moreDeps) > 0 {
-				resolveDeps(pkgName, moreDeps, dbAdapter)
-			}
-			MarkedPkgs = append(MarkedPkgs, pkgName)
-			util.Display(os.Stdout, true, "Installing %s=%s", pkgName, pkgInfo.RepoVersion)
-		}
-	}
-}
+// CacheDir is the directory the repositories' build files are extracted into.
+var CacheDir = "var/rpkgm"
 
 // Ask asks before doing any operation.
-func Ask(dbAdapter *database.Adapter) {
+func Ask(store *database.Store) {
 	// If there are marked packages, ask, else, just quit
 	if len(MarkedPkgs) > 0 { //nolint:nestif
 		var choice string
@@ -94,7 +45,7 @@ func Ask(dbAdapter *database.Adapter) {
 		_, err := fmt.Scanln(&choice)
 		if err != nil {
 			// Close the database connection
-			err = dbAdapter.CloseDBConnection()
+			err = store.CloseDBConnection()
 			if err != nil {
 				util.Display(
 					os.Stderr,
@@ -102,9 +53,9 @@ func Ask(dbAdapter *database.Adapter) {
 					"rpkgm could not close the connection to the database. Error: %s",
 					err,
 				)
-				os.Exit(1)
+				util.Exit(1)
 			}
-			os.Exit(1)
+			util.Exit(1)
 		}
 
 		// Choice is yes, we go on
@@ -113,7 +64,7 @@ func Ask(dbAdapter *database.Adapter) {
 		}
 
 		// Close the database connection
-		err = dbAdapter.CloseDBConnection()
+		err = store.CloseDBConnection()
 		if err != nil {
 			util.Display(
 				os.Stderr,
@@ -121,16 +72,16 @@ func Ask(dbAdapter *database.Adapter) {
 				"rpkgm could not close the connection to the database. Error: %s",
 				err,
 			)
-			os.Exit(1)
+			util.Exit(1)
 		}
-		os.Exit(0)
+		util.Exit(0)
 	}
 
 	// If we're here, there isn't any packages marked for an operation
 	util.Display(os.Stderr, true, "No package selected for any operations.")
 
 	// Close the database connection
-	err := dbAdapter.CloseDBConnection()
+	err := store.CloseDBConnection()
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -138,22 +89,89 @@ func Ask(dbAdapter *database.Adapter) {
 			"rpkgm could not close the connection to the database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
+	}
+	util.Exit(0)
+}
+
+// checkConflicts makes sure the files a package is about to install are neither owned by another package nor
+// already on the filesystem without being owned by any package, unless they match one of the overwrite globs.
+func checkConflicts(
+	pkgInfo database.PkgInfo,
+	entries []database.ManifestEntry,
+	overwrite []string,
+	store *database.Store,
+) error {
+	previous, err := store.GetManifest(pkgInfo.Name)
+	if err != nil {
+		return err
+	}
+
+	// A package installed before the manifest existed owns its files without rpkgm knowing it
+	isLegacy := pkgInfo.Installed && len(previous) == 0
+
+	var conflicts []string
+
+	for _, entry := range entries {
+		// Directories can be shared
+		if entry.Type == database.EntryDir {
+			continue
+		}
+
+		// The user allowed the path to be overwritten
+		if slices.ContainsFunc(overwrite, func(glob string) bool { return stage.MatchGlob(glob, entry.Path) }) {
+			continue
+		}
+
+		owners, err := store.GetPathOwners(entry.Path)
+		if err != nil {
+			return err
+		}
+
+		others := slices.DeleteFunc(owners, func(owner string) bool { return owner == pkgInfo.Name })
+		if len(others) > 0 {
+			conflicts = append(conflicts, fmt.Sprintf("%s is owned by %s", entry.Path, strings.Join(others, ", ")))
+
+			continue
+		}
+
+		// Files the package already owns can be replaced
+		if isLegacy || slices.ContainsFunc(previous, func(prev database.ManifestEntry) bool { return prev.Path == entry.Path }) {
+			continue
+		}
+
+		if _, err = os.Lstat(filepath.Join(Root, entry.Path)); err == nil {
+			conflicts = append(conflicts, fmt.Sprintf("%s exists on the filesystem and is not owned by any package", entry.Path))
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf( //nolint:goerr113
+			"%s conflicts with files on the filesystem, re-run with --overwrite <glob> to overwrite them:\n  %s",
+			pkgInfo.Name,
+			strings.Join(conflicts, "\n  "),
+		)
 	}
-	os.Exit(0)
+
+	return nil
 }
 
-// Install installs a package.
+// Install installs a package, reason being the reason it is installed for (database.ReasonExplicit or
+// database.ReasonDependency). Files conflicting with the ones on the filesystem are only overwritten
+// if they match one of the overwrite globs. The files about to be replaced are saved by the transaction's step.
 func Install( //nolint:funlen,cyclop
 	pkgInfo database.PkgInfo,
 	index, total int,
 	verbose, keep, force bool,
-	dbAdapter *database.Adapter,
+	reason string,
+	overwrite []string,
+	step transaction.Step,
+	store *database.Store,
 ) error {
 	// Set the destination directory
-	destDir := fmt.Sprintf("/tmp/rpkgm/%s", pkgInfo.Name)
+	destDir := filepath.Join(BuildDir, pkgInfo.Name)
 	if keep {
-		destDir = fmt.Sprintf("/tmp/usr/src/rpkgm/%s", pkgInfo.Name)
+		destDir = filepath.Join(SrcDir, pkgInfo.Name)
 	}
 
 	// Remove the destination directory if it already exists
@@ -252,7 +270,12 @@ func Install( //nolint:funlen,cyclop
 	}
 
 	// Set the source and destination makefiles
-	makefileSrc := fmt.Sprintf("%s/Makefile", pkgInfo.BuildFilesDir)
+	buildFilesDir, err := pkgInfo.BuildFilesPath(CacheDir)
+	if err != nil {
+		return fmt.Errorf("rpkgm was unable to find the build files of %s, Error: %w", pkgInfo.Name, err)
+	}
+
+	makefileSrc := filepath.Join(buildFilesDir, "Makefile")
 	makeFileDst := fmt.Sprintf("%s/Makefile", newDestDir)
 
 	// Copy the source make into the destination makefile
@@ -281,9 +304,19 @@ func Install( //nolint:funlen,cyclop
 		util.Rc,
 	)
 
-	// Install the package
-	install := fmt.Sprintf("cd %s && make install", newDestDir)
-	inOut, err := exec.Command("/usr/bin/env", "bash", "-c", install).CombinedOutput()
+	// Create the staging root the package is installed into before being merged into the real root
+	stagingDir := fmt.Sprintf("%s/rpkgm-destdir", destDir)
+	err = os.MkdirAll(stagingDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf(
+			"rpkgm was unable to create the staging root for %s, Error: %w",
+			pkgInfo.Name,
+			err,
+		)
+	}
+
+	// Install the package into the staging root
+	inOut, err := makeCmd(newDestDir, "install", "DESTDIR="+stagingDir).CombinedOutput()
 	if err != nil {
 		// In case of errors, be verbose to leave a trace
 		util.Display(io.Discard, true, "%s", string(inOut))
@@ -306,6 +339,75 @@ func Install( //nolint:funlen,cyclop
 		util.Display(os.Stdout, false, string(inOut))
 	}
 
+	// Record everything the package installed into the staging root
+	entries, err := stage.Scan(stagingDir, pkgInfo.Name)
+	if err != nil {
+		return fmt.Errorf(
+			"rpkgm was unable to scan the staging root of %s, Error: %w",
+			pkgInfo.Name,
+			err,
+		)
+	}
+
+	// If nothing was installed in the staging root, the Makefile likely installed directly into the real root
+	if len(entries) == 0 {
+		return fmt.Errorf( //nolint:goerr113
+			"the package %s installed nothing into its staging root, make sure its Makefile honors DESTDIR",
+			pkgInfo.Name,
+		)
+	}
+
+	// Make sure the package won't silently overwrite files it doesn't own
+	err = checkConflicts(pkgInfo, entries, overwrite, store)
+	if err != nil {
+		return err
+	}
+
+	// Save what merging and removing the previous version's files are about to change, so it can be rolled back
+	previous, err := store.GetManifest(pkgInfo.Name)
+	if err != nil {
+		return fmt.Errorf(
+			"rpkgm was unable to get the files installed by %s, Error: %w",
+			pkgInfo.Name,
+			err,
+		)
+	}
+
+	err = step.Backup(Root, append(slices.Clone(entries), previous...))
+	if err != nil {
+		return fmt.Errorf(
+			"rpkgm was unable to save the files %s is about to replace, Error: %w",
+			pkgInfo.Name,
+			err,
+		)
+	}
+
+	// Inform of the merging
+	util.Display(
+		os.Stdout, false,
+		"Merging (%s%d%s of %s%d%s) %s%s=%s%s",
+		util.By,
+		index,
+		util.Rc,
+		util.By,
+		total,
+		util.Rc,
+		util.Bg,
+		pkgInfo.Name,
+		pkgInfo.RepoVersion,
+		util.Rc,
+	)
+
+	// Merge the staging root into the real root
+	err = stage.Merge(stagingDir, Root, entries)
+	if err != nil {
+		return fmt.Errorf(
+			"rpkgm was unable to merge %s into the root, Error: %w",
+			pkgInfo.Name,
+			err,
+		)
+	}
+
 	// If we don't keep the build dir, remove it
 	if !keep {
 		// Inform of the cleaning
@@ -334,35 +436,87 @@ func Install( //nolint:funlen,cyclop
 		}
 	}
 
-	// Mark the installed package as installed
-	err = dbAdapter.MarkAsInstalled(pkgInfo.Name)
+	// Remove the files a previously installed version installed but this one doesn't
+	err = removeObsolete(pkgInfo.Name, entries, store)
 	if err != nil {
 		return fmt.Errorf(
-			"rpkgm could not mark %s as installed although the package is, in fact installed. Error: %w",
+			"rpkgm could not remove the files of %s's previous version, although the package is, in fact installed. Error: %w",
 			pkgInfo.Name,
 			err,
 		)
 	}
 
-	// Set the package's installed version to the repo's version
-	err = dbAdapter.SetInstalledVersion(pkgInfo.Name, pkgInfo.RepoVersion)
+	// An explicitly installed package stays explicitly installed, even when it's reinstalled as a dependency
+	if pkgInfo.Installed && pkgInfo.InstallReason == database.ReasonExplicit {
+		reason = database.ReasonExplicit
+	}
+
+	// Record the package as installed along with the files it installed, in a single transaction
+	return store.WithTx(func(txAdapter *database.Adapter) error {
+		err := txAdapter.SetInstalled(database.InstalledPkg{
+			Name:         pkgInfo.Name,
+			Version:      pkgInfo.RepoVersion,
+			Reason:       reason,
+			Repo:         pkgInfo.Repo,
+			Dependencies: pkgInfo.Dependencies,
+			Date:         time.Now().Format(time.RFC3339),
+		})
+		if err != nil {
+			return fmt.Errorf(
+				"rpkgm could not record %s as installed although the package is, in fact installed. Error: %w",
+				pkgInfo.Name,
+				err,
+			)
+		}
+
+		// Record the files the package installed
+		err = txAdapter.SetManifest(pkgInfo.Name, entries)
+		if err != nil {
+			return fmt.Errorf(
+				"rpkgm could not record the files installed by %s, although the package is, in fact installed. Error: %w",
+				pkgInfo.Name,
+				err,
+			)
+		}
+
+		return nil
+	})
+}
+
+// removeObsolete removes the files of a package's previous manifest that are not in its new manifest.
+func removeObsolete(pkgName string, entries []database.ManifestEntry, store *database.Store) error {
+	previous, err := store.GetManifest(pkgName)
 	if err != nil {
-		return fmt.Errorf(
-			"rpkgm could not set %s's install version in the repo, although the package is, in fact installed. Error: %w",
-			pkgInfo.Name,
-			err,
-		)
+		return err
 	}
 
-	return nil
+	// Nothing was installed before (or it was installed before the manifest existed)
+	if len(previous) == 0 {
+		return nil
+	}
+
+	newPaths := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		newPaths[entry.Path] = true
+	}
+
+	var obsolete []database.ManifestEntry
+	for _, entry := range previous {
+		if !newPaths[entry.Path] {
+			obsolete = append(obsolete, entry)
+		}
+	}
+
+	return removeFiles(pkgName, obsolete, store)
 }
 
-// uninstall uninstalls a package.
+// uninstall uninstalls a package, the files about to be removed are saved by the transaction's step.
 func uninstall( //nolint:funlen
 	pkgInfo database.PkgInfo,
 	index, total int,
 	verbose, keep bool,
-	dbAdapter *database.Adapter,
+	step transaction.Step,
+	store *database.Store,
 ) error {
 	// Inform of the uninstalling
 	util.Display(
@@ -380,16 +534,28 @@ func uninstall( //nolint:funlen
 		util.Rc,
 	)
 
-	// Uninstall the package
-	uninstall := fmt.Sprintf("cd %s && make uninstall", pkgInfo.BuildFilesDir)
-	unOut, err := exec.Command("/usr/bin/env", "bash", "-c", uninstall).CombinedOutput()
+	// Get the files the package installed
+	manifest, err := store.GetManifest(pkgInfo.Name)
 	if err != nil {
-		// In case of errors, be verbose to leave a trace
-		util.Display(io.Discard, true, "%s", string(unOut))
-		if verbose && string(unOut) != "" {
-			util.Display(os.Stdout, false, string(unOut))
+		return fmt.Errorf(
+			"rpkgm was unable to get the files installed by %s, Error: %w",
+			pkgInfo.Name,
+			err,
+		)
+	}
+
+	// Remove exactly the files the package installed, packages installed before the manifest existed
+	// have to rely on their Makefile (and can't have their files restored on rollback)
+	if len(manifest) > 0 {
+		err = step.Backup(Root, manifest)
+		if err == nil {
+			err = removeFiles(pkgInfo.Name, manifest, store)
 		}
+	} else {
+		err = legacyUninstall(pkgInfo, verbose)
+	}
 
+	if err != nil {
 		return fmt.Errorf(
 			"rpkgm was unable to uninstall the package %s, Error: %w",
 			pkgInfo.Name,
@@ -399,7 +565,7 @@ func uninstall( //nolint:funlen
 
 	// If we don't keep the source, remove it
 	if !keep {
-		workdir := fmt.Sprintf("/tmp/usr/src/rpkgm/%s", pkgInfo.Name)
+		workdir := filepath.Join(SrcDir, pkgInfo.Name)
 		if _, err := os.Stat(workdir); !os.IsNotExist(err) {
 			// Inform of the cleaning
 			util.Display(
@@ -429,66 +595,320 @@ func uninstall( //nolint:funlen
 		}
 	}
 
-	// Mark the package as not installed anymore
-	err = dbAdapter.MarkAsNotInstalled(pkgInfo.Name)
-	if err != nil {
-		return fmt.Errorf(
-			"rpkgm could not mark %s as not installed although the package is, in fact uninstalled. Error: %w",
+	// Record the package as uninstalled in a single transaction
+	return store.WithTx(func(txAdapter *database.Adapter) error {
+		err := txAdapter.RemoveInstalled(pkgInfo.Name)
+		if err != nil {
+			return fmt.Errorf(
+				"rpkgm could not record %s as not installed although the package is, in fact uninstalled. Error: %w",
+				pkgInfo.Name,
+				err,
+			)
+		}
+
+		// Forget the files the package installed
+		err = txAdapter.RemoveManifest(pkgInfo.Name)
+		if err != nil {
+			return fmt.Errorf(
+				"rpkgm could not forget the files installed by %s although the package is, in fact uninstalled. Error: %w",
+				pkgInfo.Name,
+				err,
+			)
+		}
+
+		return nil
+	})
+}
+
+// removeFiles removes the given manifest entries of a package from the root, except the ones other packages own too.
+// Files modified since their installation (ex: configuration files) are kept.
+func removeFiles(pkgName string, entries []database.ManifestEntry, store *database.Store) error {
+	toRemove := make([]database.ManifestEntry, 0, len(entries))
+	for _, entry := range entries {
+		owners, err := store.GetPathOwners(entry.Path)
+		if err != nil {
+			return err
+		}
+
+		// Leave what other packages still own
+		if slices.ContainsFunc(owners, func(owner string) bool { return owner != pkgName }) {
+			continue
+		}
+		toRemove = append(toRemove, entry)
+	}
+
+	kept, err := stage.Remove(Root, toRemove)
+	for _, path := range kept {
+		util.Display(os.Stdout, true, "Kept %s, it was modified since %s installed it.", path, pkgName)
+	}
+
+	return err
+}
+
+// makeCmd returns the command running make with the given arguments followed by MakeArgs, in a given directory.
+// No shell is involved, MakeArgs is split on whitespace.
+func makeCmd(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("make", append(args, strings.Fields(MakeArgs)...)...)
+	cmd.Dir = dir
+
+	return cmd
+}
+
+// legacyUninstall uninstalls a package using the uninstall target of its Makefile.
+func legacyUninstall(pkgInfo database.PkgInfo, verbose bool) error {
+	// The Makefile knows nothing about the root, it would uninstall the package from the host
+	if filepath.Clean(Root) != "/" {
+		return fmt.Errorf( //nolint:goerr113
+			"%s was installed before rpkgm recorded files, it can't be uninstalled from another root than /",
 			pkgInfo.Name,
-			err,
 		)
 	}
 
-	// Remove the package installed version in the repo
-	err = dbAdapter.SetInstalledVersion(pkgInfo.Name, "")
+	// Its Makefile is in its build files
+	buildFilesDir, err := pkgInfo.BuildFilesPath(CacheDir)
 	if err != nil {
-		return fmt.Errorf(
-			"rpkgm could not remove %s's installed version (in the repo's db) although the package is, in fact uninstalled. Error: %w",
-			pkgInfo.Name,
+		return fmt.Errorf("%s was installed before rpkgm recorded files and its Makefile can't be found: %w", pkgInfo.Name, err)
+	}
+
+	unOut, err := makeCmd(buildFilesDir, "uninstall").CombinedOutput()
+
+	// Log the output and display it if we're verbose
+	util.Display(io.Discard, true, "%s", string(unOut))
+	if verbose && string(unOut) != "" {
+		util.Display(os.Stdout, false, string(unOut))
+	}
+
+	return err
+}
+
+// CheckInterrupted exits if a previous transaction was interrupted, it has to be continued or rolled back first.
+func CheckInterrupted(store *database.Store) {
+	txn, err := transaction.Unfinished(store)
+	if err == nil && txn == nil {
+		return
+	}
+
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not check for an interrupted transaction. Error: %s", err)
+	} else {
+		util.Display(
+			os.Stderr,
+			true,
+			"A previous %s transaction (started %s) was interrupted, re-run rpkgm with --continue to finish it or with --rollback to undo it.",
+			txn.Operation,
+			txn.Started,
+		)
+	}
+
+	// Close the database connection
+	err = store.CloseDBConnection()
+	if err != nil {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not close the connection to the database. Error: %s",
 			err,
 		)
 	}
+	util.Exit(1)
+}
+
+// applySteps applies the steps of a transaction that are not done yet, in order, and stops at the first failure.
+func applySteps(
+	txn *transaction.Transaction,
+	verbose, keep, force bool,
+	overwrite []string,
+	store *database.Store,
+) error {
+	for seq, step := range txn.Steps {
+		if step.State == database.StepDone {
+			continue
+		}
+
+		// Record the package's state before changing it
+		pkgInfo, err := txn.Start(seq, Root)
+		if err != nil {
+			return fmt.Errorf(
+				"rpkgm could not record the state of %s in the journal. Error: %w",
+				step.Package,
+				err,
+			)
+		}
+
+		if txn.Operation == transaction.OpInstall {
+			err = Install(pkgInfo, seq+1, len(txn.Steps), verbose, keep, force, step.Reason, overwrite, txn.Step(seq), store)
+		} else {
+			err = uninstall(pkgInfo, seq+1, len(txn.Steps), verbose, keep, txn.Step(seq), store)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = txn.Finish(seq)
+		if err != nil {
+			return fmt.Errorf("rpkgm could not record %s as done in the journal. Error: %w", step.Package, err)
+		}
+	}
 
 	return nil
 }
 
+// Apply applies the steps of a transaction that are not done yet. If one of them fails, the whole transaction is
+// rolled back. Errors are displayed, the returned error only tells the caller that the transaction failed.
+func Apply(
+	txn *transaction.Transaction,
+	verbose, keep, force bool,
+	overwrite []string,
+	store *database.Store,
+) error {
+	err := applySteps(txn, verbose, keep, force, overwrite, store)
+	if err == nil {
+		err = txn.Commit()
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm could not mark the transaction as done. Error: %s", err)
+		}
+
+		return err
+	}
+
+	util.Display(os.Stderr, true, "%s", err)
+	util.Display(os.Stderr, true, "Rolling back the transaction...")
+
+	// Put back the files and the database as they were before the transaction
+	rollbackErr := txn.Rollback(Root)
+	if rollbackErr != nil {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not roll back the transaction, re-run rpkgm with --rollback to try again. Error: %s",
+			rollbackErr,
+		)
+
+		return errors.Join(err, rollbackErr)
+	}
+
+	util.Display(os.Stderr, true, "The transaction was rolled back, nothing was changed.")
+
+	return err
+}
+
+// Recover finishes the transaction that was interrupted, either by applying its remaining steps or by rolling it back.
+func Recover(doRollback, verbose, keep, force bool, overwrite []string, dbLocation database.Location) { //nolint:funlen
+	// Check if the user is root
+	util.CheckRoot("Please run rpkgm as root.")
+
+	// Connect to the databases
+	store, err := database.Open(dbLocation)
+	if err != nil {
+		util.Display(os.Stderr, false, "rpkgm could not connect to the database. Error: %s", err)
+		util.Exit(1)
+	}
+
+	// Get the interrupted transaction
+	txn, err := transaction.Unfinished(store)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not get the interrupted transaction. Error: %s", err)
+		util.Exit(1)
+	}
+
+	if txn == nil {
+		util.Display(os.Stdout, false, "No interrupted transaction to recover.")
+	} else {
+		var pkgNames []string
+		for _, step := range txn.Steps {
+			pkgNames = append(pkgNames, step.Package)
+		}
+
+		util.Display(
+			os.Stdout,
+			true,
+			"Recovering the %s transaction of %s (started %s)",
+			txn.Operation,
+			strings.Join(pkgNames, ", "),
+			txn.Started,
+		)
+
+		if doRollback {
+			err = txn.Rollback(Root)
+			if err != nil {
+				util.Display(os.Stderr, true, "rpkgm could not roll back the transaction. Error: %s", err)
+			} else {
+				util.Display(os.Stdout, true, "The transaction was rolled back.")
+			}
+		} else {
+			err = Apply(txn, verbose, keep, force, overwrite, store)
+		}
+	}
+
+	// Close the database connection
+	closeErr := store.CloseDBConnection()
+	if closeErr != nil {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not close the connection to the database. Error: %s",
+			closeErr,
+		)
+		util.Exit(1)
+	}
+
+	if err != nil {
+		util.Exit(1)
+	}
+}
+
 // Decide decides what to do based on the given booleans.
 func Decide( //nolint:funlen,gocognit,cyclop
-	doInstall, force, verbose, keep, yes, resolve bool,
-	packageList []string,
-	repoDB string,
+	doInstall, force, verbose, keep, yes, cascade, nodeps bool,
+	packageList, overwrite []string,
+	dbLocation database.Location,
 ) {
 	// Check if the user is root
 	util.CheckRoot("Please run rpkgm as root.")
 
-	// Connect to the database
-	dbAdapter, err := database.NewAdapter("sqlite3", repoDB)
+	// Connect to the databases
+	store, err := database.Open(dbLocation)
 	if err != nil {
 		util.Display(os.Stderr, false, "rpkgm could not connect to the database. Error: %s", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
+	// Refuse to do anything while a previous transaction is unfinished
+	CheckInterrupted(store)
+
+	// Packages explicitly requested for an operation, before resolving their dependencies
+	var requested []string
+
+	// Reason for which each marked package is installed
+	reasons := make(map[string]string)
+
 	for _, pkgName := range packageList {
-		// Check if the package is in the repo
-		isInRepo, _ := dbAdapter.IsPkgInRepo(pkgName)
-		if !isInRepo && !force {
+		// A package is uninstalled by its name, whichever repository it came from
+		if !doInstall {
+			_, pkgName = database.SplitName(pkgName)
+		}
+
+		// Check if the package is in a repository, an installed package can be uninstalled even if it isn't anymore
+		isInRepo, _ := store.IsPkgInRepo(pkgName)
+		if doInstall && !isInRepo && !force {
 			util.Display(
 				os.Stderr,
 				true,
-				"The package named %s is not in the repository, skipping...",
+				"The package named %s is not in any repository, skipping...",
 				pkgName,
 			)
 
 			continue
 		}
 
-		// Get the package's general information
-		pkgInfo, err := dbAdapter.GetPkgInfo(pkgName)
+		// Make sure the package's general information can be retrieved
+		_, err = store.GetPkgInfo(pkgName)
 		if err != nil {
 			util.Display(
 				os.Stderr,
 				true,
-				"rpkgm couldn't get %s's information despite it being in the repo, skipping...",
+				"rpkgm couldn't get %s's information, skipping...",
 				pkgName,
 			)
 
@@ -496,7 +916,7 @@ func Decide( //nolint:funlen,gocognit,cyclop
 		}
 
 		// Check if the package is already installed
-		isInstalled, err := dbAdapter.IsInstalled(pkgName)
+		isInstalled, err := store.IsInstalled(pkgName)
 		if err != nil {
 			util.Display(
 				os.Stderr,
@@ -508,9 +928,6 @@ func Decide( //nolint:funlen,gocognit,cyclop
 			continue
 		}
 
-		// Get the dependencies as a list
-		deps := strings.Split(pkgInfo.Dependencies, " ")
-
 		switch {
 		// Case the operation is installing, the package is already installed but we don't force the re-installation it, skip it
 		case doInstall && isInstalled && !force:
@@ -524,38 +941,12 @@ func Decide( //nolint:funlen,gocognit,cyclop
 			continue
 			// Case the operation is installing, the package is already installed and we force the re-installation, we install it
 		case doInstall && isInstalled && force:
-			// If the experimental resolve feature is set, resolve its deps
-			if resolve {
-				resolveDeps(pkgName, deps, dbAdapter)
-			} else {
-				util.Display(
-					os.Stdout,
-					true,
-					"The package %s lists %v as dependencies, resolving dependencies is still experimental, either use --resolve or install them yourself.",
-					pkgName,
-					deps,
-				)
-			}
-			util.Display(os.Stdout, true, "Installing %s=%s", pkgName, pkgInfo.RepoVersion)
-			// Mark the package for installation
-			MarkedPkgs = append(MarkedPkgs, pkgName)
+			// Request the package's installation, its dependencies will be resolved afterward
+			requested = append(requested, pkgName)
 			// Case the operation is installing and the package is not installed
 		case doInstall && !isInstalled:
-			// If the experimental resolve feature is set, resolve its deps
-			if resolve {
-				resolveDeps(pkgName, deps, dbAdapter)
-			} else {
-				util.Display(
-					os.Stdout,
-					true,
-					"The package %s lists %v as dependencies, resolving dependencies is still experimental, either use --resolve or install them yourself.",
-					pkgName,
-					deps,
-				)
-			}
-			util.Display(os.Stdout, true, "Installing %s=%s", pkgName, pkgInfo.RepoVersion)
-			// Mark the package for installation
-			MarkedPkgs = append(MarkedPkgs, pkgName)
+			// Request the package's installation, its dependencies will be resolved afterward
+			requested = append(requested, pkgName)
 			// Case the operation is uninstallation and the package is not installed, we skip it
 		case !doInstall && !isInstalled:
 			util.Display(
@@ -568,8 +959,8 @@ func Decide( //nolint:funlen,gocognit,cyclop
 			continue
 			// Case the operation is uninstallation and the package is installed, we mark it for uninstallation
 		case !doInstall && isInstalled:
-			util.Display(os.Stdout, true, "Uninstalling %s=%s", pkgName, pkgInfo.RepoVersion)
-			MarkedPkgs = append(MarkedPkgs, pkgName)
+			// Request the package's uninstallation, its reverse dependencies will be checked afterward
+			requested = append(requested, pkgName)
 			// Case I don't know what the f to do based on the given information
 		default:
 			util.Display(
@@ -583,44 +974,149 @@ func Decide( //nolint:funlen,gocognit,cyclop
 		}
 	}
 
-	// If --yes/-y is not set, we ask before doing anything
-	if !yes {
-		Ask(dbAdapter)
-	}
+	switch {
+	// Dependencies are ignored, only mark the requested packages
+	case nodeps:
+		for _, pkgName := range requested {
+			if doInstall {
+				util.Display(os.Stdout, true, "Installing %s (ignoring its dependencies)", pkgName)
+			} else {
+				util.Display(os.Stdout, true, "Uninstalling %s (ignoring its reverse dependencies)", pkgName)
+			}
 
-	for index, pkgName := range MarkedPkgs {
-		// Get the general information of the marked package
-		pkgInfo, err := dbAdapter.GetPkgInfo(pkgName)
+			// Mark the package for the operation
+			MarkedPkgs = append(MarkedPkgs, pkgName)
+			reasons[pkgName] = database.ReasonExplicit
+		}
+	// Resolve the dependencies of the requested packages and mark the whole install plan
+	case doInstall && len(requested) > 0:
+		plan, err := solver.Solve(store, requested)
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm can't satisfy the dependencies of the requested packages:\n%s", err)
+
+			// Close the database connection
+			err = store.CloseDBConnection()
+			if err != nil {
+				util.Display(
+					os.Stderr,
+					true,
+					"rpkgm could not close the connection to the database. Error: %s",
+					err,
+				)
+			}
+			util.Exit(1)
+		}
+
+		for _, step := range plan {
+			if step.Requested {
+				util.Display(os.Stdout, true, "Installing %s=%s", step.Info.FullName(), step.Info.RepoVersion)
+			} else {
+				util.Display(
+					os.Stdout,
+					true,
+					"Installing %s=%s (required by %s)",
+					step.Info.FullName(),
+					step.Info.RepoVersion,
+					strings.Join(step.RequiredBy, ", "),
+				)
+			}
+
+			// Mark the package for installation from the repository it was found in
+			MarkedPkgs = append(MarkedPkgs, step.Info.FullName())
+			reasons[step.Info.FullName()] = database.ReasonDependency
+			if step.Requested {
+				reasons[step.Info.FullName()] = database.ReasonExplicit
+			}
+		}
+	// Make sure uninstalling the requested packages won't break other installed packages and mark the uninstall plan
+	case !doInstall && len(requested) > 0:
+		removals, err := solver.SolveRemoval(store, requested, cascade)
 		if err != nil {
 			util.Display(
 				os.Stderr,
 				true,
-				"rpkgm couldn't get %s's information despite it being in the repo, skipping...",
-				pkgName,
+				"rpkgm refuses to uninstall the requested packages, it would break installed packages:\n%s\n"+
+					"You can also uninstall them by re-running with --cascade or ignore dependencies with --nodeps.",
+				err,
 			)
 
-			continue
-		}
-
-		// If the operation is installation, call install
-		if doInstall {
-			err = Install(pkgInfo, index+1, len(MarkedPkgs), verbose, keep, force, dbAdapter)
+			// Close the database connection
+			err = store.CloseDBConnection()
 			if err != nil {
-				util.Display(os.Stderr, true, "%s", err)
+				util.Display(
+					os.Stderr,
+					true,
+					"rpkgm could not close the connection to the database. Error: %s",
+					err,
+				)
 			}
+			util.Exit(1)
 		}
 
-		// If the operation is uninstallation, call uninstall
-		if !doInstall {
-			err = uninstall(pkgInfo, index+1, len(MarkedPkgs), verbose, keep, dbAdapter)
-			if err != nil {
-				util.Display(os.Stderr, true, "%s", err)
+		for _, removal := range removals {
+			if removal.Requested {
+				util.Display(os.Stdout, true, "Uninstalling %s", removal.Name)
+			} else {
+				util.Display(
+					os.Stdout,
+					true,
+					"Uninstalling %s (depends on %s)",
+					removal.Name,
+					strings.Join(removal.Requires, ", "),
+				)
 			}
+
+			// Mark the package for uninstallation
+			MarkedPkgs = append(MarkedPkgs, removal.Name)
+		}
+	}
+
+	// If --yes/-y is not set, we ask before doing anything
+	if !yes {
+		Ask(store)
+	}
+
+	operation := transaction.OpInstall
+	if !doInstall {
+		operation = transaction.OpUninstall
+	}
+
+	// Record the plan in the journal before touching anything
+	txn, err := transaction.Begin(store, operation, filepath.Dir(dbLocation.Local), MarkedPkgs, reasons)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not start the transaction. Error: %s", err)
+
+		// Close the database connection
+		err = store.CloseDBConnection()
+		if err != nil {
+			util.Display(
+				os.Stderr,
+				true,
+				"rpkgm could not close the connection to the database. Error: %s",
+				err,
+			)
+		}
+		util.Exit(1)
+	}
+
+	// Apply the plan, everything is rolled back if something fails
+	err = Apply(txn, verbose, keep, force, overwrite, store)
+	if err != nil {
+		// Close the database connection
+		err = store.CloseDBConnection()
+		if err != nil {
+			util.Display(
+				os.Stderr,
+				true,
+				"rpkgm could not close the connection to the database. Error: %s",
+				err,
+			)
 		}
+		util.Exit(1)
 	}
 
 	// Close the database connection
-	err = dbAdapter.CloseDBConnection()
+	err = store.CloseDBConnection()
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -628,6 +1124,6 @@ func Decide( //nolint:funlen,gocognit,cyclop
 			"rpkgm could not close the connection to the database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
@@ -0,0 +1,188 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package query
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/util"
+)
+
+// absPath returns the absolute and clean version of a path.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not get the absolute path of %s. Error: %s", path, err)
+		util.Exit(1)
+	}
+
+	return abs
+}
+
+// rootPath returns a path as recorded in the manifests. Paths given for another root than / are already relative to it.
+func rootPath(root, path string) string {
+	if filepath.Clean(root) == "/" {
+		return absPath(path)
+	}
+
+	return filepath.Join("/", path)
+}
+
+// printOwner prints the packages owning a given path.
+func printOwner(path, root string, store *database.Store) {
+	path = rootPath(root, path)
+
+	owners, err := store.GetPathOwners(path)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not find the owner of %s. Error: %s", path, err)
+		util.Exit(1)
+	}
+
+	if len(owners) == 0 {
+		util.Display(os.Stderr, false, "No package owns %s.", path)
+		util.Exit(1)
+	}
+
+	for _, owner := range owners {
+		// Get the owner's version, the owner may have been removed from the repo in the meantime
+		pkgInfo, err := store.GetPkgInfo(owner)
+		if err != nil {
+			util.Display(os.Stdout, false, "%s is owned by %s", path, owner)
+
+			continue
+		}
+
+		util.Display(os.Stdout, false, "%s is owned by %s=%s", path, owner, pkgInfo.InstalledVersion)
+	}
+}
+
+// printFiles prints the files installed by a given package.
+func printFiles(name string, store *database.Store) {
+	entries, err := store.GetManifest(name)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not get the files installed by %s. Error: %s", name, err)
+		util.Exit(1)
+	}
+
+	if len(entries) == 0 {
+		util.Display(
+			os.Stderr,
+			false,
+			"No files are recorded for %s, it is either not installed or was installed before rpkgm recorded files.",
+			name,
+		)
+		util.Exit(1)
+	}
+
+	for _, entry := range entries {
+		switch entry.Type {
+		case database.EntryDir:
+			util.Display(os.Stdout, false, "%s %s/", name, entry.Path)
+		case database.EntrySymlink:
+			util.Display(os.Stdout, false, "%s %s -> %s", name, entry.Path, entry.Target)
+		default:
+			util.Display(os.Stdout, false, "%s %s", name, entry.Path)
+		}
+	}
+}
+
+// printOrphansOnDisk prints the files and symlinks under a given directory that are not owned by any package.
+func printOrphansOnDisk(dir, root string, store *database.Store) {
+	dir = rootPath(root, dir)
+
+	paths, err := store.GetOwnedPaths(dir)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not get the files owned under %s. Error: %s", dir, err)
+		util.Exit(1)
+	}
+
+	owned := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		owned[path] = true
+	}
+
+	err = filepath.WalkDir(filepath.Join(root, dir), func(path string, dirEntry fs.DirEntry, err error) error {
+		// Keep going if a directory can't be read, but say so
+		if err != nil {
+			if errors.Is(err, fs.ErrPermission) {
+				util.Display(os.Stderr, false, "rpkgm could not read %s. Error: %s", path, err)
+
+				return nil
+			}
+
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		// Compare the path as it is seen from the root
+		path = filepath.Join("/", rel)
+		if !dirEntry.IsDir() && !owned[path] {
+			util.Display(os.Stdout, false, "%s", path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not walk %s. Error: %s", dir, err)
+		util.Exit(1)
+	}
+}
+
+// Decide decides what to do based on the given strings, paths are looked up in root.
+func Decide(dbLocation database.Location, root, owns, files, orphansOnDisk string) {
+	// Connect to the database
+	store, err := database.Open(dbLocation)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not connect to the database. Error: %s", err)
+		util.Exit(1)
+	}
+
+	// Show which package owns a path
+	if owns != "" {
+		printOwner(owns, root, store)
+	}
+
+	// Show the files installed by a package
+	if files != "" {
+		printFiles(files, store)
+	}
+
+	// Show the files no package owns under a directory
+	if orphansOnDisk != "" {
+		printOrphansOnDisk(orphansOnDisk, root, store)
+	}
+
+	// Close the database connection
+	err = store.CloseDBConnection()
+	if err != nil {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not close the connection to the database. Error: %s",
+			err,
+		)
+		util.Exit(1)
+	}
+}
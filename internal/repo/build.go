@@ -0,0 +1,353 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/keyring"
+	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/redds-be/rpkgm/internal/version"
+)
+
+// MetadataFile is the file describing a package in its build files directory, it isn't part of the bundle.
+const MetadataFile = "package.json"
+
+// Metadata defines what a package's metadata file holds. Name defaults to the name of the build files directory,
+// ArchiveFile is a local copy of the archive which is hashed instead of downloading the archive from its URL and Sha512,
+// when given, has to be the hash of the archive.
+type Metadata struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Version      string `json:"version"`
+	ArchiveURL   string `json:"archiveUrl"`
+	ArchiveFile  string `json:"archiveFile"`
+	Sha512       string `json:"sha512"`
+	Dependencies string `json:"dependencies"`
+}
+
+// problems collects what is wrong with the packages of a tree, so that all of it is reported at once.
+type problems []string
+
+// add records a problem of a package.
+func (probs *problems) add(pkgDir, format string, args ...any) {
+	*probs = append(*probs, filepath.Base(pkgDir)+": "+fmt.Sprintf(format, args...))
+}
+
+// readMetadata reads the metadata file of a package, unknown fields are refused.
+func readMetadata(pkgDir string) (Metadata, error) {
+	content, err := os.ReadFile(filepath.Join(pkgDir, MetadataFile))
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	var metadata Metadata
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(&metadata)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	if metadata.Name == "" {
+		metadata.Name = filepath.Base(pkgDir)
+	}
+
+	return metadata, nil
+}
+
+// hashArchive returns the sha512 hash of a package's archive, taken from its local copy if it has one.
+func hashArchive(pkgDir string, metadata Metadata) (string, error) {
+	if metadata.ArchiveFile != "" {
+		archive := metadata.ArchiveFile
+		if !filepath.IsAbs(archive) {
+			archive = filepath.Join(pkgDir, archive)
+		}
+
+		return util.HashFile(archive)
+	}
+
+	tmpFile, err := os.CreateTemp("", "rpkgm-archive-*")
+	if err != nil {
+		return "", err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return "", err
+	}
+
+	var hash string
+
+	err = util.Download(tmpFile.Name(), metadata.ArchiveURL)
+	if err == nil {
+		hash, err = util.HashFile(tmpFile.Name())
+	}
+
+	return hash, errors.Join(err, os.Remove(tmpFile.Name()))
+}
+
+// checkBuildFiles makes sure a package's build files can be bundled and installed: there is a Makefile and every file
+// is either a regular file or a directory.
+func checkBuildFiles(pkgDir string, probs *problems) {
+	if _, err := os.Stat(filepath.Join(pkgDir, "Makefile")); err != nil {
+		probs.add(pkgDir, "there is no Makefile")
+	}
+
+	err := filepath.WalkDir(pkgDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			probs.add(pkgDir, "%s is neither a regular file nor a directory, it can't be bundled", path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		probs.add(pkgDir, "its build files can't be read: %s", err)
+	}
+}
+
+// checkPkg validates the metadata of a package and returns it as it goes in repo.json.
+func checkPkg(pkgDir string, probs *problems) database.Package { //nolint:cyclop
+	metadata, err := readMetadata(pkgDir)
+	if err != nil {
+		probs.add(pkgDir, "%s can't be read: %s", MetadataFile, err)
+
+		return database.Package{}
+	}
+
+	if metadata.Name != filepath.Base(pkgDir) {
+		probs.add(pkgDir, "the name %q is not the one of its directory", metadata.Name)
+	}
+
+	if !version.IsValidName(metadata.Name) {
+		probs.add(pkgDir, "%q is not a valid package name", metadata.Name)
+	}
+
+	if _, err = version.Parse(metadata.Version); err != nil {
+		probs.add(pkgDir, "the version %q is invalid: %s", metadata.Version, err)
+	}
+
+	if _, err = version.ParseConstraints(metadata.Dependencies); err != nil {
+		probs.add(pkgDir, "the dependencies list is invalid: %s", err)
+	}
+
+	if !util.IsDownloadable(metadata.ArchiveURL) {
+		probs.add(pkgDir, "the archive URL %q is not an http://, https:// nor file:// URL", metadata.ArchiveURL)
+	} else {
+		hash, err := hashArchive(pkgDir, metadata)
+
+		switch {
+		case err != nil:
+			probs.add(pkgDir, "the archive can't be hashed: %s", err)
+		case metadata.Sha512 != "" && !strings.EqualFold(metadata.Sha512, hash):
+			probs.add(pkgDir, "the archive's hash is %s, not the given one", hash)
+		default:
+			metadata.Sha512 = hash
+		}
+	}
+
+	checkBuildFiles(pkgDir, probs)
+
+	if metadata.Description == "" {
+		metadata.Description = "[No description provided for this package.]"
+	}
+
+	// The build files dir is relative to the repo's directory of the cache, where the bundle is extracted
+	return database.Package{
+		Name:          metadata.Name,
+		Description:   metadata.Description,
+		Version:       metadata.Version,
+		BuildFilesDir: metadata.Name,
+		ArchiveURL:    metadata.ArchiveURL,
+		Sha512:        metadata.Sha512,
+		Dependencies:  metadata.Dependencies,
+	}
+}
+
+// writeBundle writes the build files of the packages in a gzipped tarball, each under a directory named after it.
+func writeBundle(bundle, dir string, pkgs []database.Package) error {
+	file, err := os.Create(bundle)
+	if err != nil {
+		return err
+	}
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, pkg := range pkgs {
+		pkgDir := filepath.Join(dir, pkg.Name)
+
+		err = filepath.WalkDir(pkgDir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// The metadata file only describes the package
+			if path == filepath.Join(pkgDir, MetadataFile) {
+				return nil
+			}
+
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			return addToBundle(tarWriter, path, filepath.ToSlash(relPath), entry)
+		})
+		if err != nil {
+			return errors.Join(err, tarWriter.Close(), gzipWriter.Close(), file.Close())
+		}
+	}
+
+	return errors.Join(tarWriter.Close(), gzipWriter.Close(), file.Close())
+}
+
+// addToBundle adds a file or a directory to the bundle under a given name.
+func addToBundle(tarWriter *tar.Writer, path, name string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+
+	// Directories come before their files, so that they are created first when extracted
+	header.Name = name
+	if entry.IsDir() {
+		header.Name += "/"
+
+		return tarWriter.WriteHeader(header)
+	}
+
+	err = tarWriter.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tarWriter, file)
+
+	return errors.Join(err, file.Close())
+}
+
+// Build generates repo.json and the <repoName>.tar.gz bundle of the build files, as sync downloads them, from a tree
+// of build files directories, one per package, each holding a metadata file. Every problem found is reported before
+// giving up. When a private key is given, both files are signed with it.
+func Build(dir, repoName, outDir, privateKey string) { //nolint:funlen
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		util.Display(os.Stderr, false, "rpkgm could not read the packages' directory. Error: %s", err)
+		util.Exit(1)
+	}
+
+	var (
+		pkgs  database.Packages
+		probs problems
+	)
+
+	for _, entry := range entries {
+		// Only directories are packages, hidden ones are left out (ex: .git)
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		pkg := checkPkg(filepath.Join(dir, entry.Name()), &probs)
+		pkgs.Packages = append(pkgs.Packages, pkg)
+	}
+
+	if len(probs) > 0 {
+		for _, prob := range probs {
+			util.Display(os.Stderr, false, "%s", prob)
+		}
+
+		util.Display(os.Stderr, false, "rpkgm found %d problem(s), nothing was built.", len(probs))
+		util.Exit(1)
+	}
+
+	if len(pkgs.Packages) == 0 {
+		util.Display(os.Stderr, false, "rpkgm found no package in %s, nothing was built.", dir)
+		util.Exit(1)
+	}
+
+	err = os.MkdirAll(outDir, os.ModePerm)
+	if err != nil {
+		util.Display(os.Stderr, false, "rpkgm could not create the output directory. Error: %s", err)
+		util.Exit(1)
+	}
+
+	content, err := json.MarshalIndent(pkgs, "", "  ")
+	if err != nil {
+		util.Display(os.Stderr, false, "rpkgm could not encode repo.json. Error: %s", err)
+		util.Exit(1)
+	}
+
+	importFile := filepath.Join(outDir, "repo.json")
+
+	err = os.WriteFile(importFile, append(content, '\n'), 0o644) //nolint:gomnd
+	if err != nil {
+		util.Display(os.Stderr, false, "rpkgm could not write repo.json. Error: %s", err)
+		util.Exit(1)
+	}
+
+	bundle := filepath.Join(outDir, repoName+".tar.gz")
+
+	err = writeBundle(bundle, dir, pkgs.Packages)
+	if err != nil {
+		util.Display(os.Stderr, false, "rpkgm could not write the build files bundle. Error: %s", err)
+		util.Exit(1)
+	}
+
+	// Both files are signed with the time, so that the ones built next are newer
+	if privateKey != "" {
+		serial := time.Now().Unix()
+
+		for _, path := range []string{importFile, bundle} {
+			err = keyring.Sign(privateKey, path, repoName, serial)
+			if err != nil {
+				util.Display(os.Stderr, false, "rpkgm could not sign %s. Error: %s", path, err)
+				util.Exit(1)
+			}
+		}
+	}
+
+	util.Display(os.Stdout, false, "Built %s and %s with %d package(s).", importFile, bundle, len(pkgs.Packages))
+}
@@ -0,0 +1,341 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package repo
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/redds-be/rpkgm/internal/version"
+)
+
+// sha512HexLen is the length of a sha512 hash written in hexadecimal.
+const sha512HexLen = 128
+
+// Problem defines something wrong in a repo.json file, Path is the JSON path of the faulty value (ex:
+// $.packages[2].sha512).
+type Problem struct {
+	File    string
+	Line    int
+	Path    string
+	Message string
+}
+
+// String returns the problem as file:line: path: message.
+func (prob Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", prob.File, prob.Line, prob.Path, prob.Message)
+}
+
+// field defines a member of a JSON object.
+type field struct {
+	key   string
+	value *node
+}
+
+// node defines a JSON value along with the line it ends at.
+type node struct {
+	line   int
+	token  json.Token
+	fields []field
+	items  []*node
+}
+
+// kind returns the JSON type of a value.
+func (val *node) kind() string {
+	switch token := val.token.(type) {
+	case json.Delim:
+		if token == '{' {
+			return "object"
+		}
+
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+// parser reads a JSON document as a tree of nodes, remembering the line of every value.
+type parser struct {
+	content []byte
+	decoder *json.Decoder
+}
+
+// lineAt returns the line of an offset of a content.
+func lineAt(content []byte, offset int64) int {
+	return bytes.Count(content[:offset], []byte("\n")) + 1
+}
+
+// line returns the line the decoder is at.
+func (pars parser) line() int {
+	return lineAt(pars.content, pars.decoder.InputOffset())
+}
+
+// parse reads the next value.
+func (pars parser) parse() (*node, error) {
+	token, err := pars.decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	val := &node{line: pars.line(), token: token}
+
+	delim, isDelim := token.(json.Delim)
+	if !isDelim {
+		return val, nil
+	}
+
+	for pars.decoder.More() {
+		if delim == '[' {
+			item, err := pars.parse()
+			if err != nil {
+				return nil, err
+			}
+
+			val.items = append(val.items, item)
+
+			continue
+		}
+
+		key, err := pars.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		member, err := pars.parse()
+		if err != nil {
+			return nil, err
+		}
+
+		val.fields = append(val.fields, field{key: key.(string), value: member}) //nolint:forcetypeassert
+	}
+
+	// The closing delimiter
+	_, err = pars.decoder.Token()
+
+	return val, err
+}
+
+// linter collects the problems of a repo.json file.
+type linter struct {
+	file     string
+	problems []Problem
+}
+
+// report records a problem of a value.
+func (lint *linter) report(val *node, path, format string, args ...any) {
+	lint.problems = append(lint.problems, Problem{
+		File:    lint.file,
+		Line:    val.line,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// fields returns the members of an object by key, reporting the unknown and the duplicate ones.
+func (lint *linter) fields(val *node, path string, known ...string) map[string]*node {
+	members := make(map[string]*node, len(val.fields))
+
+	for _, member := range val.fields {
+		memberPath := path + "." + member.key
+
+		switch {
+		case !slices.Contains(known, member.key):
+			lint.report(member.value, memberPath, "unknown field (expected one of %s)", strings.Join(known, ", "))
+		case members[member.key] != nil:
+			lint.report(member.value, memberPath, "duplicate field, first set on line %d", members[member.key].line)
+		default:
+			members[member.key] = member.value
+		}
+	}
+
+	return members
+}
+
+// str returns the string a field holds, reporting it if it's not a string or if it's required and missing or empty.
+func (lint *linter) str(parent *node, members map[string]*node, path, key string, isRequired bool) string {
+	val, isSet := members[key]
+	if !isSet {
+		if isRequired {
+			lint.report(parent, path, "missing required field %q", key)
+		}
+
+		return ""
+	}
+
+	str, isString := val.token.(string)
+	if !isString {
+		lint.report(val, path+"."+key, "expected a string, got a %s", val.kind())
+
+		return ""
+	}
+
+	if isRequired && str == "" {
+		lint.report(val, path+"."+key, "must not be empty")
+	}
+
+	return str
+}
+
+// lintPkg checks a package of the packages array.
+func (lint *linter) lintPkg(val *node, path string, names map[string]int) { //nolint:cyclop
+	if val.kind() != "object" {
+		lint.report(val, path, "expected an object, got a %s", val.kind())
+
+		return
+	}
+
+	members := lint.fields(val, path,
+		"name", "description", "version", "buildFilesDir", "archiveUrl", "sha512", "dependencies")
+
+	if name := lint.str(val, members, path, "name", true); name != "" {
+		firstLine, isDuplicate := names[name]
+
+		switch {
+		case !version.IsValidName(name):
+			lint.report(members["name"], path+".name", "%q is not a valid package name", name)
+		case isDuplicate:
+			lint.report(members["name"], path+".name", "duplicate package %q, first declared on line %d", name, firstLine)
+		default:
+			names[name] = members["name"].line
+		}
+	}
+
+	lint.str(val, members, path, "description", false)
+
+	if pkgVersion := lint.str(val, members, path, "version", true); pkgVersion != "" {
+		if _, err := version.Parse(pkgVersion); err != nil {
+			lint.report(members["version"], path+".version", "%s", err)
+		}
+	}
+
+	if buildFilesDir := lint.str(val, members, path, "buildFilesDir", false); slices.Contains(strings.Split(buildFilesDir, "/"), "..") {
+		lint.report(members["buildFilesDir"], path+".buildFilesDir", "must not go up a directory (..)")
+	}
+
+	archiveURL := lint.str(val, members, path, "archiveUrl", true)
+	if archiveURL != "" && !util.IsDownloadable(archiveURL) {
+		lint.report(members["archiveUrl"], path+".archiveUrl", "%q is not an http://, https:// nor file:// URL", archiveURL)
+	}
+
+	if hash := lint.str(val, members, path, "sha512", true); hash != "" {
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != sha512HexLen {
+			lint.report(members["sha512"], path+".sha512", "expected %d hexadecimal characters", sha512HexLen)
+		}
+	}
+
+	if deps := lint.str(val, members, path, "dependencies", false); deps != "" {
+		if _, err := version.ParseConstraints(deps); err != nil {
+			lint.report(members["dependencies"], path+".dependencies", "%s", err)
+		}
+	}
+}
+
+// Lint validates a repo.json file and returns every problem found, in the order they appear in the file.
+// A file that isn't valid JSON has a single problem, where the syntax breaks.
+func Lint(path string) ([]Problem, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lint := linter{file: path}
+	pars := parser{content: content, decoder: json.NewDecoder(bytes.NewReader(content))}
+
+	root, err := pars.parse()
+	if err == nil {
+		// Nothing may follow the document
+		if _, err = pars.decoder.Token(); errors.Is(err, io.EOF) {
+			err = nil
+		} else if err == nil {
+			err = errors.New("unexpected data after the document") //nolint:goerr113
+		}
+	}
+
+	if err != nil {
+		// A syntax error knows where it is, other errors are where the decoder stopped
+		offset := pars.decoder.InputOffset()
+
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			offset = syntaxErr.Offset
+		}
+
+		lint.report(&node{line: lineAt(content, offset)}, "$", "%s", err)
+
+		return lint.problems, nil
+	}
+
+	if root.kind() != "object" {
+		lint.report(root, "$", "expected an object, got a %s", root.kind())
+
+		return lint.problems, nil
+	}
+
+	pkgs, isSet := lint.fields(root, "$", "packages")["packages"]
+
+	switch {
+	case !isSet:
+		lint.report(root, "$", "missing required field %q", "packages")
+	case pkgs.kind() != "array":
+		lint.report(pkgs, "$.packages", "expected an array, got a %s", pkgs.kind())
+	default:
+		names := make(map[string]int)
+		for index, pkg := range pkgs.items {
+			lint.lintPkg(pkg, fmt.Sprintf("$.packages[%d]", index), names)
+		}
+	}
+
+	// The fields of an object are checked before their values, so the problems are sorted back in the file's order
+	slices.SortStableFunc(lint.problems, func(prob, other Problem) int { return cmp.Compare(prob.Line, other.Line) })
+
+	return lint.problems, nil
+}
+
+// CheckFile lints a repo.json file before it's used, rpkgm exits after reporting the problems if there are any.
+func CheckFile(path string) {
+	problems, err := Lint(path)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm couldn't read %s. Error: %s", path, err)
+		util.Exit(1)
+	}
+
+	if len(problems) == 0 {
+		return
+	}
+
+	for _, prob := range problems {
+		util.Display(os.Stderr, true, "%s", prob)
+	}
+
+	util.Display(os.Stderr, true, "rpkgm refuses %s, it has %d problem(s).", path, len(problems))
+	util.Exit(1)
+}
@@ -0,0 +1,149 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package repo_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/redds-be/rpkgm/internal/repo"
+)
+
+// hash is a valid sha512 hash, as 128 hexadecimal characters.
+var hash = strings.Repeat("ab", 64)
+
+// expected defines a problem Lint has to report: where it is and a part of its message.
+type expected struct {
+	line    int
+	path    string
+	message string
+}
+
+func TestLint(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+		want    []expected
+	}{
+		{
+			name: "valid",
+			content: `{"packages": [
+  {"name": "foo", "version": "1.0", "archiveUrl": "https://example.com/foo.tar.gz", "sha512": "` + hash + `",
+   "dependencies": "bar>=1.2"}
+]}`,
+		},
+		{
+			name: "local archive",
+			content: `{"packages": [
+  {"name": "foo", "version": "1.0", "archiveUrl": "file:///srv/mirror/foo.tar.gz", "sha512": "` + hash + `"}
+]}`,
+		},
+		{
+			name:    "syntax error",
+			content: "{\n  \"packages\": [\n    {\"name\": \"foo\",}\n  ]\n}",
+			want:    []expected{{3, "$", "invalid character"}},
+		},
+		{
+			name:    "not an object",
+			content: "[]",
+			want:    []expected{{1, "$", "expected an object, got a array"}},
+		},
+		{
+			name:    "no packages",
+			content: "{\n  \"pkgs\": []\n}",
+			want: []expected{
+				{1, "$", `missing required field "packages"`},
+				{2, "$.pkgs", "unknown field"},
+			},
+		},
+		{
+			name: "invalid fields",
+			content: `{
+  "packages": [
+    {
+      "name": "foo",
+      "version": "1.0>",
+      "archiveUrl": "ftp://example.com/foo.tar.gz",
+      "sha512": "abc",
+      "buildFilesDir": "../foo",
+      "dependencies": "bar>>1",
+      "license": "GPL"
+    },
+    "bar"
+  ]
+}`,
+			want: []expected{
+				{5, "$.packages[0].version", "invalid version"},
+				{6, "$.packages[0].archiveUrl", "is not an http://, https:// nor file:// URL"},
+				{7, "$.packages[0].sha512", "expected 128 hexadecimal characters"},
+				{8, "$.packages[0].buildFilesDir", "must not go up a directory"},
+				{9, "$.packages[0].dependencies", "invalid dependency constraint"},
+				{10, "$.packages[0].license", "unknown field"},
+				{12, "$.packages[1]", "expected an object, got a string"},
+			},
+		},
+		{
+			name: "missing and duplicate",
+			content: `{"packages": [
+  {"name": "foo", "version": "1.0", "archiveUrl": "https://example.com/foo.tar.gz", "sha512": "` + hash + `"},
+  {"name": "foo", "version": "", "archiveUrl": "https://example.com/foo.tar.gz",
+   "name": "bar"}
+]}`,
+			want: []expected{
+				{3, "$.packages[1].name", `duplicate package "foo", first declared on line 2`},
+				{3, "$.packages[1].version", "must not be empty"},
+				{3, "$.packages[1]", `missing required field "sha512"`},
+				{4, "$.packages[1].name", "duplicate field, first set on line 3"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "repo.json")
+
+		err := os.WriteFile(path, []byte(test.content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		problems, err := repo.Lint(path)
+		if err != nil {
+			t.Errorf("%s: Lint returned an error: %s", test.name, err)
+
+			continue
+		}
+
+		if len(problems) != len(test.want) {
+			t.Errorf("%s: Lint returned %d problems, want %d: %v", test.name, len(problems), len(test.want), problems)
+
+			continue
+		}
+
+		for index, problem := range problems {
+			want := test.want[index]
+			if problem.File != path || problem.Line != want.line || problem.Path != want.path ||
+				!strings.Contains(problem.Message, want.message) {
+				t.Errorf("%s: problem %d is %q, want line %d, path %s and a message containing %q",
+					test.name, index, problem, want.line, want.path, want.message)
+			}
+		}
+	}
+}
@@ -9,10 +9,11 @@ import (
 	"github.com/redds-be/rpkgm/internal/util"
 )
 
-// printLicense prints the license of a given package assuming the license if in the build files.
-func printLicense(name string, dbAdapter *database.Adapter) {
+// printLicense prints the license of a given package assuming the license if in the build files, cacheDir being where
+// the repositories' build files are.
+func printLicense(name, cacheDir string, store *database.Store) {
 	// Find the build files for the given package, the license should be in there
-	buildFilesDir, err := dbAdapter.GetPkgBuildFilesDir(name)
+	buildFilesDir, err := store.GetPkgBuildFilesDir(name, cacheDir)
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -20,7 +21,7 @@ func printLicense(name string, dbAdapter *database.Adapter) {
 			"rpkgm could not find the build files (build files includes the license) for the given package. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Open the license file
@@ -32,7 +33,7 @@ func printLicense(name string, dbAdapter *database.Adapter) {
 			"rpkgm could not open or find the license file for the given package. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Read the license file
@@ -43,7 +44,7 @@ func printLicense(name string, dbAdapter *database.Adapter) {
 			"rpkgm could not read from the license file of the given package. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Print the license file's content
@@ -57,14 +58,25 @@ func printLicense(name string, dbAdapter *database.Adapter) {
 			"rpkgm could not close the license file for the given package. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
 
+// installedStatus returns the installed version of a package and why it is installed, foreign packages are the ones no
+// repository has anymore.
+func installedStatus(pkgInfo database.PkgInfo) string {
+	status := fmt.Sprintf("Installed (%s, %s)", pkgInfo.InstalledVersion, pkgInfo.InstallReason)
+	if pkgInfo.IsForeign() {
+		status += ", foreign"
+	}
+
+	return status
+}
+
 // printInfo prints the general information of a given package.
-func printInfo(name string, dbAdapter *database.Adapter) {
+func printInfo(name string, store *database.Store) {
 	// Get the given package's general info
-	pkgInfo, err := dbAdapter.GetPkgInfo(name)
+	pkgInfo, err := store.GetPkgInfo(name)
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -72,28 +84,33 @@ func printInfo(name string, dbAdapter *database.Adapter) {
 			"rpkgm could not get the given package's information. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Display the general info (changes depending on the installation status)
 	if pkgInfo.Installed {
 		util.Display(
 			os.Stdout, false,
-			"%s [Installed (%s)]\t- %s\t- Repo's version: %s",
-			pkgInfo.Name,
-			pkgInfo.InstalledVersion,
+			"%s [%s]\t- %s\t- Repo's version: %s",
+			pkgInfo.FullName(),
+			installedStatus(pkgInfo),
 			pkgInfo.Description,
 			pkgInfo.RepoVersion,
 		)
+
+		// Packages installed before the date was recorded don't have one
+		if pkgInfo.InstallDate != "" {
+			util.Display(os.Stdout, false, "Installed on %s from %s", pkgInfo.InstallDate, pkgInfo.InstalledRepo)
+		}
 	} else {
-		util.Display(os.Stdout, false, "%s [Not installed]\t- %s\t- Repo's version: %s", pkgInfo.Name, pkgInfo.Description, pkgInfo.RepoVersion)
+		util.Display(os.Stdout, false, "%s [Not installed]\t- %s\t- Repo's version: %s", pkgInfo.FullName(), pkgInfo.Description, pkgInfo.RepoVersion)
 	}
 }
 
 // printAllinfo prints the general information of every package in the repo.
-func printAllinfo(dbAdapter *database.Adapter) {
+func printAllinfo(store *database.Store) {
 	// Get every package info in the database
-	allPkgInfo, err := dbAdapter.GetAllPkgInfo()
+	allPkgInfo, err := store.GetAllPkgInfo()
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -101,7 +118,7 @@ func printAllinfo(dbAdapter *database.Adapter) {
 			"rpkgm could query the repo's database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// For every package, display the info (changes depending on the installation status)
@@ -109,22 +126,22 @@ func printAllinfo(dbAdapter *database.Adapter) {
 		if pkgInfo.Installed {
 			util.Display(
 				os.Stdout, false,
-				"%s [Installed (%s)]\t- %s\t- Repo's version: %s",
-				pkgInfo.Name,
-				pkgInfo.InstalledVersion,
+				"%s [%s]\t- %s\t- Repo's version: %s",
+				pkgInfo.FullName(),
+				installedStatus(pkgInfo),
 				pkgInfo.Description,
 				pkgInfo.RepoVersion,
 			)
 		} else {
-			util.Display(os.Stdout, false, "%s [Not installed]\t- %s\t- Repo's version: %s", pkgInfo.Name, pkgInfo.Description, pkgInfo.RepoVersion)
+			util.Display(os.Stdout, false, "%s [Not installed]\t- %s\t- Repo's version: %s", pkgInfo.FullName(), pkgInfo.Description, pkgInfo.RepoVersion)
 		}
 	}
 }
 
-// Decide decides what to do based on the given booleans.
-func Decide(repoDB, name string, showLicense, showInfo, showAll bool) {
+// Decide decides what to do based on the given booleans, cacheDir being where the repositories' build files are.
+func Decide(dbLocation database.Location, cacheDir, name string, showLicense, showInfo, showAll bool) {
 	// Connect to the database
-	dbAdapter, err := database.NewAdapter("sqlite3", repoDB)
+	store, err := database.Open(dbLocation)
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -132,26 +149,26 @@ func Decide(repoDB, name string, showLicense, showInfo, showAll bool) {
 			"rpkgm could connect to the repo's database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Show the license of the package
 	if showLicense {
-		printLicense(name, dbAdapter)
+		printLicense(name, cacheDir, store)
 	}
 
 	// Show the general info of the package
 	if showInfo {
-		printInfo(name, dbAdapter)
+		printInfo(name, store)
 	}
 
 	// Show the general info of all the packages
 	if showAll {
-		printAllinfo(dbAdapter)
+		printAllinfo(store)
 	}
 
 	// Close the database connection
-	err = dbAdapter.CloseDBConnection()
+	err = store.CloseDBConnection()
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -159,6 +176,6 @@ func Decide(repoDB, name string, showLicense, showInfo, showAll bool) {
 			"rpkgm could not close the connection to the database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
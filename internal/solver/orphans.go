@@ -0,0 +1,84 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package solver
+
+import (
+	"fmt"
+
+	"github.com/redds-be/rpkgm/internal/database"
+)
+
+// OrphanSource defines where the solver gets the installed packages and their dependencies from.
+type OrphanSource interface {
+	GetInstalledPkgInfo() ([]database.PkgInfo, error)
+	GetDependencies(name string) ([]database.Dependency, error)
+}
+
+// Orphans returns the installed packages that were only installed as dependencies and that no explicitly installed
+// package needs anymore, directly or not.
+func Orphans(src OrphanSource) ([]string, error) {
+	installed, err := src.GetInstalledPkgInfo()
+	if err != nil {
+		return nil, fmt.Errorf("could not get the installed packages: %w", err)
+	}
+
+	isInstalled := make(map[string]bool, len(installed))
+	for _, info := range installed {
+		isInstalled[info.Name] = true
+	}
+
+	// Walk the dependencies of every explicitly installed package, everything reached is needed
+	needed := make(map[string]bool)
+
+	var walk func(pkgName string) error
+	walk = func(pkgName string) error {
+		if needed[pkgName] || !isInstalled[pkgName] {
+			return nil
+		}
+		needed[pkgName] = true
+
+		deps, err := src.GetDependencies(pkgName)
+		if err != nil {
+			return fmt.Errorf("could not get the dependencies of %s: %w", pkgName, err)
+		}
+
+		for _, dep := range deps {
+			if err = walk(dep.DependsOn); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, info := range installed {
+		if info.InstallReason != database.ReasonDependency {
+			if err = walk(info.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var orphans []string
+	for _, info := range installed {
+		if !needed[info.Name] {
+			orphans = append(orphans, info.Name)
+		}
+	}
+
+	return orphans, nil
+}
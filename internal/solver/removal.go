@@ -0,0 +1,131 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package solver
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/redds-be/rpkgm/internal/database"
+)
+
+// ErrRequired is returned when uninstalling packages would break installed packages depending on them.
+var ErrRequired = errors.New("is required by")
+
+// RemovalSource defines where the solver gets the installed packages' reverse dependencies from.
+type RemovalSource interface {
+	IsInstalled(name string) (bool, error)
+	GetReverseDependencies(name string) ([]database.Dependency, error)
+}
+
+// Removal defines a package to uninstall in an uninstall plan.
+type Removal struct {
+	Name      string
+	Requested bool
+	Requires  []string
+}
+
+// SolveRemoval returns the uninstall plan of the requested packages, each package coming before the packages it
+// depends on. If an installed package depends on a package to uninstall, every such package is returned as an error,
+// unless cascade is set, in which case the dependent packages are uninstalled too.
+func SolveRemoval(src RemovalSource, requested []string, cascade bool) ([]Removal, error) { //nolint:cyclop
+	removals := make(map[string]*Removal)
+	queue := make([]string, 0, len(requested))
+
+	for _, pkgName := range requested {
+		removals[pkgName] = &Removal{Name: pkgName, Requested: true}
+		queue = append(queue, pkgName)
+	}
+
+	// Installed dependents of each package to uninstall
+	dependents := make(map[string][]string)
+
+	var errs []error
+
+	for len(queue) > 0 {
+		pkgName := queue[0]
+		queue = queue[1:]
+
+		revDeps, err := src.GetReverseDependencies(pkgName)
+		if err != nil {
+			return nil, fmt.Errorf("could not get the reverse dependencies of %s: %w", pkgName, err)
+		}
+
+		var broken []string
+		for _, revDep := range revDeps {
+			isInstalled, err := src.IsInstalled(revDep.Package)
+			if err != nil || !isInstalled || slices.Contains(dependents[pkgName], revDep.Package) {
+				continue
+			}
+			dependents[pkgName] = append(dependents[pkgName], revDep.Package)
+
+			// The dependent is already going away
+			if removal, isRemoved := removals[revDep.Package]; isRemoved {
+				removal.Requires = append(removal.Requires, pkgName)
+
+				continue
+			}
+
+			if !cascade {
+				broken = append(broken, revDep.Package)
+
+				continue
+			}
+
+			// Cascade, the dependent has to go too, and so do its own dependents
+			removals[revDep.Package] = &Removal{Name: revDep.Package, Requires: []string{pkgName}}
+			queue = append(queue, revDep.Package)
+		}
+
+		if len(broken) > 0 {
+			errs = append(errs, fmt.Errorf("%s %w %s", pkgName, ErrRequired, strings.Join(broken, ", ")))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// Order the plan so that dependents are uninstalled before what they depend on
+	var order []Removal
+
+	visited := make(map[string]bool)
+
+	var visit func(pkgName string)
+	visit = func(pkgName string) {
+		if visited[pkgName] {
+			return
+		}
+		visited[pkgName] = true
+
+		for _, dependent := range dependents[pkgName] {
+			if _, isRemoved := removals[dependent]; isRemoved {
+				visit(dependent)
+			}
+		}
+
+		order = append(order, *removals[pkgName])
+	}
+
+	for _, pkgName := range requested {
+		visit(pkgName)
+	}
+
+	return order, nil
+}
@@ -0,0 +1,272 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package solver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/version"
+)
+
+// Errors returned by the solver, wrapped with more context.
+var (
+	ErrNotInRepo     = errors.New("not in any repository")
+	ErrCycle         = errors.New("dependency cycle")
+	ErrUnsatisfiable = errors.New("unsatisfiable dependency")
+)
+
+// Source defines where the solver gets the packages' information from.
+type Source interface {
+	IsPkgInRepo(name string) (bool, error)
+	GetPkgInfo(name string) (database.PkgInfo, error)
+}
+
+// Step defines a package to install in an install plan.
+type Step struct {
+	Info       database.PkgInfo
+	Requested  bool
+	RequiredBy []string
+}
+
+// requirement defines a constraint put on a package by another package.
+type requirement struct {
+	from       string
+	constraint version.Constraint
+}
+
+// node states used to walk the graph.
+const (
+	visiting = iota + 1
+	visited
+)
+
+// solver holds the state of the dependency graph while solving.
+type solver struct {
+	src          Source
+	infos        map[string]database.PkgInfo
+	states       map[string]int
+	planned      map[string]*Step
+	requirements map[string][]requirement
+	order        []string
+	path         []string
+	errs         []error
+}
+
+// Solve builds the dependency graph of the requested packages and returns a topologically ordered install plan
+// (dependencies first). Every requested package is part of the plan, dependencies are only part of it when they
+// are not installed or when their installed version does not satisfy the constraints put on them.
+// If a dependency is missing, can't be satisfied or if there's a cycle, every problem is returned as an error.
+func Solve(src Source, requested []string) ([]Step, error) {
+	slvr := &solver{
+		src:          src,
+		infos:        make(map[string]database.PkgInfo),
+		states:       make(map[string]int),
+		planned:      make(map[string]*Step),
+		requirements: make(map[string][]requirement),
+	}
+
+	// Look every requested package up first, a package requested from a given repository (ex: extra/foo) is then
+	// the one its dependents get too
+	var requestedInfos []database.PkgInfo
+	for _, pkgName := range requested {
+		info, err := slvr.lookup(pkgName)
+		if err == nil {
+			err = slvr.checkInRepo(pkgName)
+		}
+
+		if err != nil {
+			slvr.errs = append(slvr.errs, fmt.Errorf("%s is %w", pkgName, err))
+
+			continue
+		}
+
+		slvr.infos[info.Name] = info
+		requestedInfos = append(requestedInfos, info)
+	}
+
+	for _, info := range requestedInfos {
+		slvr.plan(info).Requested = true
+		slvr.visit(info.Name)
+	}
+
+	// Every planned package has to satisfy every constraint put on it
+	slvr.checkRequirements()
+
+	if len(slvr.errs) > 0 {
+		return nil, errors.Join(slvr.errs...)
+	}
+
+	steps := make([]Step, 0, len(slvr.order))
+	for _, pkgName := range slvr.order {
+		steps = append(steps, *slvr.planned[pkgName])
+	}
+
+	return steps, nil
+}
+
+// lookup returns the information about a package, ErrNotInRepo is returned if it's neither in any repository nor
+// installed.
+func (slvr *solver) lookup(pkgName string) (database.PkgInfo, error) {
+	if info, isKnown := slvr.infos[pkgName]; isKnown {
+		return info, nil
+	}
+
+	info, err := slvr.src.GetPkgInfo(pkgName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.PkgInfo{}, ErrNotInRepo
+	}
+
+	if err != nil {
+		return database.PkgInfo{}, err
+	}
+	slvr.infos[pkgName] = info
+
+	return info, nil
+}
+
+// checkInRepo makes sure a package to install is in a repository, ErrNotInRepo is returned otherwise (ex: an installed
+// package no repository has anymore).
+func (slvr *solver) checkInRepo(pkgName string) error {
+	isInRepo, err := slvr.src.IsPkgInRepo(pkgName)
+	if err != nil {
+		return err
+	}
+
+	if !isInRepo {
+		return ErrNotInRepo
+	}
+
+	return nil
+}
+
+// plan adds a package to the install plan (if it's not already in it) and returns its step.
+func (slvr *solver) plan(info database.PkgInfo) *Step {
+	step, isPlanned := slvr.planned[info.Name]
+	if !isPlanned {
+		step = &Step{Info: info}
+		slvr.planned[info.Name] = step
+	}
+
+	return step
+}
+
+// visit walks the dependencies of a planned package, depth first, and appends it to the order once they are visited.
+func (slvr *solver) visit(pkgName string) { //nolint:cyclop
+	switch slvr.states[pkgName] {
+	case visiting:
+		// The package is already being visited, which means we went around in a circle
+		cycleStart := slices.Index(slvr.path, pkgName)
+		cycle := append(slices.Clone(slvr.path[cycleStart:]), pkgName)
+		slvr.errs = append(slvr.errs, fmt.Errorf("%w: %s", ErrCycle, strings.Join(cycle, " -> ")))
+
+		return
+	case visited:
+		return
+	}
+
+	slvr.states[pkgName] = visiting
+	slvr.path = append(slvr.path, pkgName)
+
+	constraints, err := version.ParseConstraints(slvr.planned[pkgName].Info.Dependencies)
+	if err != nil {
+		slvr.errs = append(slvr.errs, fmt.Errorf("%s has an invalid dependencies list: %w", pkgName, err))
+	}
+
+	for _, constraint := range constraints {
+		info, err := slvr.lookup(constraint.Name)
+		if err != nil {
+			slvr.errs = append(slvr.errs, fmt.Errorf("%s requires %s, which is %w", pkgName, constraint, err))
+
+			continue
+		}
+
+		_, isPlanned := slvr.planned[constraint.Name]
+
+		// Keep an installed dependency if it satisfies the constraint and nothing else asked to install it, whether a
+		// repository has it or not
+		isKept := false
+		if !isPlanned && info.Installed {
+			isOk, err := constraint.Allows(info.InstalledVersion)
+			isKept = err == nil && isOk
+		}
+
+		// Otherwise it has to be installed or upgraded from a repository
+		if !isPlanned && !isKept {
+			if err = slvr.checkInRepo(constraint.Name); err != nil {
+				slvr.errs = append(slvr.errs, fmt.Errorf("%s requires %s, which is %w", pkgName, constraint, err))
+
+				continue
+			}
+		}
+
+		slvr.requirements[constraint.Name] = append(
+			slvr.requirements[constraint.Name],
+			requirement{from: pkgName, constraint: constraint},
+		)
+
+		if isKept {
+			continue
+		}
+
+		step := slvr.plan(info)
+		step.RequiredBy = append(step.RequiredBy, pkgName)
+		slvr.visit(constraint.Name)
+	}
+
+	slvr.path = slvr.path[:len(slvr.path)-1]
+	slvr.states[pkgName] = visited
+	slvr.order = append(slvr.order, pkgName)
+}
+
+// checkRequirements makes sure every constraint put on a package is satisfied by the version that will be installed
+// (or by the one that stays installed).
+func (slvr *solver) checkRequirements() {
+	// Go through the packages in a stable order to get stable error messages
+	names := make([]string, 0, len(slvr.requirements))
+	for pkgName := range slvr.requirements {
+		names = append(names, pkgName)
+	}
+	slices.Sort(names)
+
+	for _, pkgName := range names {
+		info := slvr.infos[pkgName]
+
+		candidate, origin := info.InstalledVersion, "installed version"
+		if _, isPlanned := slvr.planned[pkgName]; isPlanned {
+			candidate, origin = info.RepoVersion, "repo's version"
+		}
+
+		for _, req := range slvr.requirements[pkgName] {
+			isOk, err := req.constraint.Allows(candidate)
+			if err != nil || !isOk {
+				slvr.errs = append(slvr.errs, fmt.Errorf(
+					"%w: %s requires %s but the %s is %s",
+					ErrUnsatisfiable,
+					req.from,
+					req.constraint,
+					origin,
+					candidate,
+				))
+			}
+		}
+	}
+}
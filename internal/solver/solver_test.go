@@ -0,0 +1,240 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package solver_test
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/solver"
+)
+
+// source is an in-memory solver.Source, packages without a repo version aren't in any repository.
+type source map[string]database.PkgInfo
+
+// IsPkgInRepo returns true if a repository has the package.
+func (src source) IsPkgInRepo(name string) (bool, error) {
+	return src[name].RepoVersion != "", nil
+}
+
+// GetPkgInfo returns the information about a package, sql.ErrNoRows is returned if it's unknown.
+func (src source) GetPkgInfo(name string) (database.PkgInfo, error) {
+	info, isKnown := src[name]
+	if !isKnown {
+		return database.PkgInfo{}, sql.ErrNoRows
+	}
+
+	info.Name = name
+
+	return info, nil
+}
+
+// repoPkg returns the information about a package a repository has at the given version.
+func repoPkg(version, dependencies string) database.PkgInfo {
+	return database.PkgInfo{RepoVersion: version, Dependencies: dependencies}
+}
+
+// installedPkg returns the information about a package installed at a version, a repository has it at another one.
+func installedPkg(installed, version, dependencies string) database.PkgInfo {
+	return database.PkgInfo{
+		RepoVersion:      version,
+		InstalledVersion: installed,
+		Installed:        true,
+		Dependencies:     dependencies,
+	}
+}
+
+func TestSolve(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		src       source
+		requested []string
+		want      []string
+		wantErr   error
+	}{
+		{
+			name:      "no dependencies",
+			src:       source{"foo": repoPkg("1.0", "")},
+			requested: []string{"foo"},
+			want:      []string{"foo"},
+		},
+		{
+			name: "dependencies first",
+			src: source{
+				"foo": repoPkg("1.0", "bar"),
+				"bar": repoPkg("1.0", "baz>=2"),
+				"baz": repoPkg("2.1", ""),
+			},
+			requested: []string{"foo"},
+			want:      []string{"baz", "bar", "foo"},
+		},
+		{
+			name: "shared dependency planned once",
+			src: source{
+				"app":   repoPkg("1.0", "left right"),
+				"left":  repoPkg("1.0", "base"),
+				"right": repoPkg("1.0", "base>1"),
+				"base":  repoPkg("1.5", ""),
+			},
+			requested: []string{"app"},
+			want:      []string{"base", "left", "right", "app"},
+		},
+		{
+			name: "installed dependency satisfying its constraint is kept",
+			src: source{
+				"foo": repoPkg("1.0", "bar>=1.2"),
+				"bar": installedPkg("1.2", "1.3", ""),
+			},
+			requested: []string{"foo"},
+			want:      []string{"foo"},
+		},
+		{
+			name: "installed dependency too old is upgraded",
+			src: source{
+				"foo": repoPkg("1.0", "bar>=1.2"),
+				"bar": installedPkg("1.1", "1.3", ""),
+			},
+			requested: []string{"foo"},
+			want:      []string{"bar", "foo"},
+		},
+		{
+			name: "requested package is reinstalled",
+			src: source{
+				"foo": installedPkg("1.0", "1.0", "bar"),
+				"bar": installedPkg("1.0", "1.0", ""),
+			},
+			requested: []string{"foo"},
+			want:      []string{"foo"},
+		},
+		{
+			name: "installed foreign dependency satisfying its constraint is kept",
+			src: source{
+				"foo": repoPkg("1.0", "bar>=1.2"),
+				"bar": installedPkg("1.2", "", ""),
+			},
+			requested: []string{"foo"},
+			want:      []string{"foo"},
+		},
+		{
+			name: "installed foreign dependency too old",
+			src: source{
+				"foo": repoPkg("1.0", "bar>=1.2"),
+				"bar": installedPkg("1.1", "", ""),
+			},
+			requested: []string{"foo"},
+			wantErr:   solver.ErrNotInRepo,
+		},
+		{
+			name:      "requested foreign package",
+			src:       source{"foo": installedPkg("1.0", "", "")},
+			requested: []string{"foo"},
+			wantErr:   solver.ErrNotInRepo,
+		},
+		{
+			name:      "requested package not in the repository",
+			src:       source{},
+			requested: []string{"foo"},
+			wantErr:   solver.ErrNotInRepo,
+		},
+		{
+			name:      "missing dependency",
+			src:       source{"foo": repoPkg("1.0", "bar")},
+			requested: []string{"foo"},
+			wantErr:   solver.ErrNotInRepo,
+		},
+		{
+			name: "cycle",
+			src: source{
+				"foo": repoPkg("1.0", "bar"),
+				"bar": repoPkg("1.0", "baz"),
+				"baz": repoPkg("1.0", "foo"),
+			},
+			requested: []string{"foo"},
+			wantErr:   solver.ErrCycle,
+		},
+		{
+			name:      "self dependency",
+			src:       source{"foo": repoPkg("1.0", "foo")},
+			requested: []string{"foo"},
+			wantErr:   solver.ErrCycle,
+		},
+		{
+			name: "repository's version too old",
+			src: source{
+				"foo": repoPkg("1.0", "bar>=2"),
+				"bar": repoPkg("1.5", ""),
+			},
+			requested: []string{"foo"},
+			wantErr:   solver.ErrUnsatisfiable,
+		},
+		{
+			name: "conflicting constraints",
+			src: source{
+				"foo": repoPkg("1.0", "lib>=2"),
+				"bar": repoPkg("1.0", "lib<2"),
+				"lib": repoPkg("2.1", ""),
+			},
+			requested: []string{"foo", "bar"},
+			wantErr:   solver.ErrUnsatisfiable,
+		},
+		{
+			name: "kept installed version breaks a requested package's constraint",
+			src: source{
+				"foo": repoPkg("1.0", "lib>=1"),
+				"bar": repoPkg("1.0", "lib<1"),
+				"lib": installedPkg("1.0", "1.0", ""),
+			},
+			requested: []string{"foo", "bar"},
+			wantErr:   solver.ErrUnsatisfiable,
+		},
+	}
+
+	for _, test := range tests {
+		steps, err := solver.Solve(test.src, test.requested)
+		if test.wantErr != nil {
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("%s: Solve returned %v, want %v", test.name, err, test.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: Solve returned an error: %s", test.name, err)
+
+			continue
+		}
+
+		got := make([]string, 0, len(steps))
+		for _, step := range steps {
+			got = append(got, step.Info.Name)
+
+			if isRequested := slices.Contains(test.requested, step.Info.Name); step.Requested != isRequested {
+				t.Errorf("%s: %s is requested: %t, want %t", test.name, step.Info.Name, step.Requested, isRequested)
+			}
+		}
+
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: Solve planned %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package stage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/util"
+)
+
+// ErrUnsupportedType is returned when a staged entry is neither a file, a directory nor a symlink.
+var ErrUnsupportedType = errors.New("unsupported file type")
+
+// modeMask keeps the permission bits along with setuid, setgid and sticky.
+const modeMask = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// Scan walks a staging root and returns the manifest of everything in it, parents coming before their children.
+func Scan(stagingDir, pkgName string) ([]database.ManifestEntry, error) {
+	var entries []database.ManifestEntry
+
+	err := filepath.WalkDir(stagingDir, func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// The staging root itself is the root the package will be installed into
+		if path == stagingDir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(stagingDir, path)
+		if err != nil {
+			return err
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := database.ManifestEntry{
+			Package: pkgName,
+			Path:    "/" + filepath.ToSlash(rel),
+			Mode:    uint32(info.Mode() & modeMask),
+		}
+
+		switch {
+		case info.Mode().IsDir():
+			entry.Type = database.EntryDir
+		case info.Mode().IsRegular():
+			entry.Type = database.EntryFile
+			entry.Size = info.Size()
+
+			entry.Sha512, err = util.HashFile(path)
+			if err != nil {
+				return err
+			}
+		case info.Mode()&fs.ModeSymlink != 0:
+			entry.Type = database.EntrySymlink
+
+			entry.Target, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: %s (%s)", ErrUnsupportedType, entry.Path, info.Mode().Type())
+		}
+
+		entries = append(entries, entry)
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// Merge installs the scanned entries of a staging root into the real root.
+// Files and symlinks are replaced atomically, existing directories are kept as they are.
+func Merge(stagingDir, root string, entries []database.ManifestEntry) error {
+	for _, entry := range entries {
+		src := filepath.Join(stagingDir, entry.Path)
+		dst := filepath.Join(root, entry.Path)
+
+		var err error
+
+		switch entry.Type {
+		case database.EntryDir:
+			err = mergeDir(dst, fs.FileMode(entry.Mode))
+		case database.EntryFile:
+			err = mergeFile(src, dst, fs.FileMode(entry.Mode))
+		case database.EntrySymlink:
+			err = mergeSymlink(dst, entry.Target)
+		default:
+			err = fmt.Errorf("%w: %s", ErrUnsupportedType, entry.Type)
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not merge %s: %w", entry.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// mergeDir creates a directory if it does not exist yet.
+func mergeDir(dst string, mode fs.FileMode) error {
+	info, err := os.Lstat(dst)
+	if err == nil {
+		// A symlink to a directory (ex: /lib -> /usr/lib) is as good as a directory
+		if info.IsDir() {
+			return nil
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if target, err := os.Stat(dst); err == nil && target.IsDir() {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("%s exists and is not a directory", dst) //nolint:goerr113
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	err = os.Mkdir(dst, mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	// Mkdir is subject to the umask, chmod isn't
+	return os.Chmod(dst, mode)
+}
+
+// mergeFile copies a staged file next to its destination and renames it over the destination.
+func mergeFile(src, dst string, mode fs.FileMode) error {
+	tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".rpkgm-new.%s", filepath.Base(dst)))
+
+	// Remove a leftover of a previous failed merge, the temporary file is then created anew so that nothing put in
+	// its place (ex: a symlink) is written through
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	destination, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return errors.Join(err, source.Close())
+	}
+
+	// Copy the staged file into the temporary file
+	_, err = io.Copy(destination, source)
+	if err != nil {
+		return errors.Join(err, source.Close(), destination.Close(), os.Remove(tmp))
+	}
+
+	if err = source.Close(); err != nil {
+		return errors.Join(err, destination.Close(), os.Remove(tmp))
+	}
+
+	if err = destination.Close(); err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+
+	// OpenFile is subject to the umask and ignores setuid/setgid, chmod doesn't
+	if err = os.Chmod(tmp, mode); err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+
+	// Replace the destination
+	if err = os.Rename(tmp, dst); err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+
+	return nil
+}
+
+// mergeSymlink creates a symlink next to its destination and renames it over the destination.
+func mergeSymlink(dst, target string) error {
+	tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".rpkgm-new.%s", filepath.Base(dst)))
+
+	// Remove a leftover of a previous failed merge
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+
+	return nil
+}
+
+// Remove removes the given entries from the root, children before their parents, and returns the paths it kept.
+// Files and symlinks that were modified since their installation are kept, directories are only removed when empty.
+func Remove(root string, entries []database.ManifestEntry) ([]string, error) { //nolint:cyclop
+	var kept []string
+
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		entry := entries[idx]
+		dst := filepath.Join(root, entry.Path)
+
+		// Never remove the root itself
+		if filepath.Clean(dst) == filepath.Clean(root) {
+			continue
+		}
+
+		info, err := os.Lstat(dst)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return kept, err
+		}
+
+		switch entry.Type {
+		case database.EntryDir:
+			// Directories that aren't empty are still used by something else, keep them silently
+			if info.IsDir() {
+				_ = os.Remove(dst)
+			}
+
+			continue
+		case database.EntryFile:
+			if !info.Mode().IsRegular() || info.Size() != entry.Size {
+				kept = append(kept, entry.Path)
+
+				continue
+			}
+
+			hash, err := util.HashFile(dst)
+			if err != nil {
+				return kept, err
+			}
+
+			if hash != entry.Sha512 {
+				kept = append(kept, entry.Path)
+
+				continue
+			}
+		case database.EntrySymlink:
+			target, err := os.Readlink(dst)
+			if err != nil || target != entry.Target {
+				kept = append(kept, entry.Path)
+
+				continue
+			}
+		}
+
+		if err = os.Remove(dst); err != nil {
+			return kept, fmt.Errorf("could not remove %s: %w", entry.Path, err)
+		}
+	}
+
+	return kept, nil
+}
+
+// MatchGlob reports whether a path matches a glob, * and ? also match / so that /usr/lib/* covers every file under
+// /usr/lib. [...] matches a character of a class (ex: [a-z]), [!...] a character out of it.
+func MatchGlob(glob, path string) bool {
+	var builder strings.Builder
+
+	builder.WriteString("^")
+
+	runes := []rune(glob)
+	for idx := 0; idx < len(runes); idx++ {
+		switch runes[idx] {
+		case '*':
+			builder.WriteString(".*")
+		case '?':
+			builder.WriteString(".")
+		case '[':
+			class, length := globClass(runes[idx+1:])
+			if length == 0 {
+				builder.WriteString(regexp.QuoteMeta("["))
+
+				continue
+			}
+			builder.WriteString(class)
+			idx += length
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(runes[idx])))
+		}
+	}
+
+	builder.WriteString("$")
+
+	matches, err := regexp.MatchString(builder.String(), path)
+
+	return err == nil && matches
+}
+
+// globClass translates the character class of a glob starting right after its [ to a regexp, along with how many
+// characters it spans up to its ]. A leading ! negates the class and a ] right after the [ (or [!) is a character of
+// it. 0 is returned if the class isn't closed. Every character but the - of ranges is escaped.
+func globClass(runes []rune) (string, int) {
+	var builder strings.Builder
+
+	builder.WriteString("[")
+
+	idx := 0
+	if idx < len(runes) && runes[idx] == '!' {
+		builder.WriteString("^")
+		idx++
+	}
+
+	for start := idx; idx < len(runes); idx++ {
+		switch {
+		case runes[idx] == ']' && idx > start:
+			builder.WriteString("]")
+
+			return builder.String(), idx + 1
+		case runes[idx] == '-' && idx > start && idx+1 < len(runes) && runes[idx+1] != ']':
+			builder.WriteString("-")
+		case runes[idx] == '-':
+			builder.WriteString(`\-`)
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(runes[idx])))
+		}
+	}
+
+	return "", 0
+}
@@ -0,0 +1,97 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package stage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/stage"
+)
+
+func TestMatchGlob(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"/usr/lib/*", "/usr/lib/libfoo.so", true},
+		{"/usr/lib/*", "/usr/lib/foo/bar.so", true},
+		{"/usr/lib/*", "/usr/lib", false},
+		{"/etc/foo.conf", "/etc/foo.conf", true},
+		{"/etc/foo.conf", "/etc/fooXconf", false},
+		{"/etc/foo?.conf", "/etc/foo1.conf", true},
+		{"/etc/foo?.conf", "/etc/foo.conf", false},
+		{"/etc/foo[12].conf", "/etc/foo2.conf", true},
+		{"/etc/foo[12].conf", "/etc/foo3.conf", false},
+		{"/etc/foo[a-c]", "/etc/foob", true},
+		{"/etc/foo[a-c]", "/etc/foo-", false},
+		{"/etc/foo[!a]", "/etc/foob", true},
+		{"/etc/foo[!a]", "/etc/fooa", false},
+		{"/etc/foo[!a]", "/etc/foo!", true},
+		{"/etc/foo[-a]", "/etc/foo-", true},
+		{"/etc/foo[]a]", "/etc/foo]", true},
+		{"/etc/foo[^a]", "/etc/foo^", true},
+		{"/etc/foo[^a]", "/etc/foob", false},
+		{"/etc/foo[.]", "/etc/foox", false},
+		{"/etc/foo[.]", "/etc/foo.", true},
+		{"/etc/foo[ab", "/etc/foo[ab", true},
+		{"/etc/(foo)+", "/etc/(foo)+", true},
+		{"/etc/(foo)+", "/etc/foo", false},
+		{"/etc/é[é]", "/etc/éé", true},
+	}
+
+	for _, test := range tests {
+		if got := stage.MatchGlob(test.glob, test.path); got != test.want {
+			t.Errorf("MatchGlob(%q, %q) = %t, want %t", test.glob, test.path, got, test.want)
+		}
+	}
+}
+
+func TestMergeLeftover(t *testing.T) {
+	t.Parallel()
+
+	stagingDir, root, outside := t.TempDir(), t.TempDir(), filepath.Join(t.TempDir(), "outside")
+
+	err := os.WriteFile(filepath.Join(stagingDir, "foo.conf"), []byte("new"), 0o600)
+	if err == nil {
+		err = os.WriteFile(outside, []byte("outside"), 0o600)
+	}
+
+	// A previous failed merge left its temporary file, which was replaced by a symlink
+	if err == nil {
+		err = os.Symlink(outside, filepath.Join(root, ".rpkgm-new.foo.conf"))
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = stage.Merge(stagingDir, root, []database.ManifestEntry{{Path: "/foo.conf", Type: database.EntryFile, Mode: 0o644}})
+	if err != nil {
+		t.Fatalf("Merge returned an error: %s", err)
+	}
+
+	for path, want := range map[string]string{filepath.Join(root, "foo.conf"): "new", outside: "outside"} {
+		if content, err := os.ReadFile(path); err != nil || string(content) != want {
+			t.Errorf("%s holds %q (error: %v), want %q", path, content, err, want)
+		}
+	}
+}
@@ -6,70 +6,249 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/redds-be/rpkgm/internal/add"
 	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/keyring"
+	"github.com/redds-be/rpkgm/internal/repo"
 	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/redds-be/rpkgm/internal/version"
 )
 
-// dlFromRemote downloads the repo's JSON file and the packages build files from a remote.
-func dlFromRemote(remote, repoName string) string {
-	destDir := fmt.Sprintf("var/rpkgm/%s", repoName)
+// CacheDir is the directory the repositories' files are downloaded into.
+var CacheDir = "var/rpkgm"
 
-	err := os.MkdirAll(destDir, os.ModePerm)
+// Keyring holds the keys the repositories' files have to be signed with.
+var Keyring keyring.Keyring
+
+// RepoKeys holds the names of the keys of the keyring each repository's files can be signed with.
+var RepoKeys map[string][]string
+
+// dlSignature downloads the detached signature of a file, a repo that doesn't sign its files has none.
+func dlSignature(dest, url string) {
+	err := util.Download(dest+keyring.SignatureExt, url+keyring.SignatureExt)
+	if err == nil {
+		return
+	}
+
+	// Neither keep the signature of a previous sync nor what was written of a failed download
+	err = os.Remove(dest + keyring.SignatureExt)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		util.Display(os.Stderr, true, "rpkgm could not remove the signature of %s. Error: %s", dest, err)
+		util.Exit(1)
+	}
+}
+
+// checkSignature makes sure a file is signed for the repo by one of its keys, with a serial no older than the one
+// accepted last time, the sync stops otherwise unless it's insecure. The serial to accept next time is returned.
+func checkSignature(path, repo string, minSerial int64, insecure bool) int64 {
+	if insecure {
+		util.Display(os.Stderr, true, "rpkgm does not check the signature of %s (--insecure).", path)
+
+		return minSerial
+	}
+
+	key, sig, err := Keyring.Verify(path, keyring.Trust{Repo: repo, Keys: RepoKeys[repo], MinSerial: minSerial})
 	if err != nil {
 		util.Display(
 			os.Stderr,
 			true,
-			"rpkgm could not create the destination directory for the build files.",
+			"rpkgm refuses %s, use --insecure to sync it anyway. Error: %s",
+			path,
+			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
-	archive := fmt.Sprintf("var/rpkgm/%s/%s.tar.gz", repoName, repoName)
+	util.Display(os.Stdout, false, "%s is signed by %s (%s).", filepath.Base(path), key.Name, key.Fingerprint())
 
-	err = util.Download(
-		archive,
-		fmt.Sprintf("https://%s/raw/main/%s.tar.gz", remote, repoName),
-	)
+	return sig.Serial
+}
+
+// fileURL returns the URL of a repo's file, source being the URL of the repo's files with {file} standing for its name.
+func fileURL(source, name string) string {
+	return strings.ReplaceAll(source, "{file}", name)
+}
+
+// lastFetched returns the repo's files as they were fetched by its last sync, none were if it was never synced.
+func lastFetched(repo database.RepoLocation, files []string) map[string]database.Fetched {
+	fetched := make(map[string]database.Fetched, len(files))
+	for _, file := range files {
+		fetched[file] = database.Fetched{File: file}
+	}
+
+	if _, err := os.Stat(repo.Path); err != nil {
+		return fetched
+	}
+
+	// Connect to the database
+	dbAdapter, err := database.NewAdapter("sqlite3", repo.Path)
 	if err != nil {
-		util.Display(os.Stderr, true, "rpkgm could not download the build files.")
-		os.Exit(1)
+		util.Display(os.Stderr, true, "rpkgm could not connect to the database. Error: %s", err)
+		util.Exit(1)
 	}
 
-	importFile := fmt.Sprintf("var/rpkgm/%s/repo.json", repoName)
+	for _, file := range files {
+		fetched[file], err = dbAdapter.GetFetched(file)
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm could not get how %s was last fetched. Error: %s", file, err)
+			util.Exit(1)
+		}
+	}
 
-	err = util.Download(
-		importFile,
-		fmt.Sprintf("https://%s/raw/main/repo.json", remote),
-	)
+	// Close the database connection
+	err = dbAdapter.CloseDBConnection()
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not close the connection to the database. Error: %s", err)
+		util.Exit(1)
+	}
+
+	return fetched
+}
+
+// fetch downloads a file of the repo into destDir unless it didn't change since it was last fetched, according to its
+// server or to its hash. A file that changed is only kept once its signature is checked.
+func fetch(destDir, repo, source string, last database.Fetched, insecure bool) (database.Fetched, bool) {
+	dest := filepath.Join(destDir, last.File)
+	url := fileURL(source, last.File)
+
+	validators := util.Validators{ETag: last.ETag, LastModified: last.LastModified}
+
+	// Validators only identify the file at the URL they were sent for, and the file kept since it was last fetched may
+	// have been replaced (ex: by a download whose signature was refused)
+	if isKept, _ := util.Verify(dest, last.Sha512); !isKept || last.URL != url {
+		validators = util.Validators{}
+	}
+
+	validators, isChanged, err := util.DownloadIfChanged(dest, url, validators)
 	if err != nil {
-		util.Display(os.Stderr, true, "rpkgm could not download the JSON file of the repo.")
-		os.Exit(1)
+		util.Display(os.Stderr, true, "rpkgm could not download %s from %s. Error: %s", last.File, url, err)
+		util.Exit(1)
+	}
+
+	fetched := database.Fetched{
+		File:         last.File,
+		URL:          url,
+		ETag:         validators.ETag,
+		LastModified: validators.LastModified,
+		Sha512:       last.Sha512,
+		Serial:       last.Serial,
+	}
+	if !isChanged {
+		return fetched, false
+	}
+
+	fetched.Sha512, err = util.HashFile(dest)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not hash %s. Error: %s", dest, err)
+		util.Exit(1)
+	}
+
+	// Sent again (ex: by a server without validators or from a file:// URL) but the same as last time
+	if fetched.Sha512 == last.Sha512 {
+		return fetched, false
 	}
 
-	_, err = util.Untar(destDir, archive)
+	// Nothing the remote sent is used before it's trusted
+	dlSignature(dest, url)
+	fetched.Serial = checkSignature(dest, repo, last.Serial, insecure)
+
+	return fetched, true
+}
+
+// dlFromRemote downloads the repo's JSON file and the packages build files from where the repo's files are (http(s)://
+// or file://), along with their signatures, unless they didn't change since the last sync. The build files are only
+// extracted once their signature is checked. What was fetched is returned along with the JSON file, nothing is if
+// neither file changed. When forced, both files are downloaded and used as if the repo was never synced.
+func dlFromRemote(repo database.RepoLocation, insecure, force bool) (string, []database.Fetched) { //nolint:funlen
+	destDir := filepath.Join(CacheDir, repo.Name)
+
+	err := os.MkdirAll(destDir, os.ModePerm)
 	if err != nil {
 		util.Display(
 			os.Stderr,
 			true,
-			"rpkgm could not untar the repo's archive. Error: %s",
-			err,
+			"rpkgm could not create the destination directory for the build files.",
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
-	return importFile
+	archiveName := repo.Name + ".tar.gz"
+
+	files := []string{archiveName, "repo.json"}
+	last := lastFetched(repo, files)
+
+	// Forgetting how the files were last fetched downloads and uses them again, an older signature is still refused
+	if force {
+		for _, file := range files {
+			last[file] = database.Fetched{File: file, Serial: last[file].Serial}
+		}
+	}
+
+	archiveFetched, isArchiveChanged := fetch(destDir, repo.Name, repo.Source, last[archiveName], insecure)
+	importFetched, isImportChanged := fetch(destDir, repo.Name, repo.Source, last["repo.json"], insecure)
+
+	if !isArchiveChanged && !isImportChanged {
+		return "", nil
+	}
+
+	// The build files extracted by a previous sync are still the ones of the archive
+	if isArchiveChanged {
+		_, err = util.Untar(destDir, filepath.Join(destDir, archiveName))
+		if err != nil {
+			util.Display(
+				os.Stderr,
+				true,
+				"rpkgm could not untar the repo's archive. Error: %s",
+				err,
+			)
+			util.Exit(1)
+		}
+	}
+
+	return filepath.Join(destDir, "repo.json"), []database.Fetched{archiveFetched, importFetched}
+}
+
+// summary defines what a sync changed in a repo.
+type summary struct {
+	added   []string
+	updated []string
+	removed []string
 }
 
-// syncWithFile syncs a repo using a file.
-func syncWithFile(importFile string, dbAdapter *database.Adapter) { //nolint:funlen,cyclop
+// count returns how many packages a change was applied to along with their names, if any (ex: 2 added (foo, bar)).
+func count(names []string, change string) string {
+	if len(names) == 0 {
+		return "0 " + change
+	}
+
+	return fmt.Sprintf("%d %s (%s)", len(names), change, strings.Join(names, ", "))
+}
+
+// isUnchanged checks if a package of the file is the same as the one in the repo.
+func isUnchanged(pkgInfo database.PkgInfo, pkg database.Package) bool {
+	return pkgInfo.Description == pkg.Description &&
+		pkgInfo.RepoVersion == pkg.Version &&
+		pkgInfo.BuildFilesDir == pkg.BuildFilesDir &&
+		pkgInfo.ArchiveURL == pkg.ArchiveURL &&
+		pkgInfo.Sha512 == pkg.Sha512 &&
+		pkgInfo.Dependencies == pkg.Dependencies
+}
+
+// syncWithFile reconciles a repo with a file: the packages that are new to the repo are added, the ones that changed
+// are updated and the ones the file doesn't have anymore are removed. An empty description, build files dir or
+// dependencies list keeps its previous value.
+func syncWithFile(importFile string, dbAdapter *database.Adapter) summary { //nolint:funlen,cyclop,gocognit
+	// Refuse a file with problems, before anything is changed
+	repo.CheckFile(importFile)
+
 	// Open the file to import
 	jsonPkgFile, err := os.Open(importFile)
 	if err != nil {
 		util.Display(os.Stderr, true, "rpkgm couldn't open the json file. Error: %s", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Read the file's content
@@ -80,7 +259,7 @@ func syncWithFile(importFile string, dbAdapter *database.Adapter) { //nolint:fun
 			"rpkgm couldn't read the json file's content. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
 	// Initialize the Packages struct
@@ -90,140 +269,347 @@ func syncWithFile(importFile string, dbAdapter *database.Adapter) { //nolint:fun
 	err = json.Unmarshal(contentInbytes, &pkgs)
 	if err != nil {
 		util.Display(os.Stderr, true, "rpkgm couldn't read the json file. Error: %s", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
-	// for every package in the json file, update their record
-	for index := 0; index < len(pkgs.Packages); index++ {
-		// Since UPDATE will set null strings as null in the database,
-		// we need to get the old information to avoid deleting some information if the JSON field is null.
-		pkgInfo, err := dbAdapter.GetPkgInfo(pkgs.Packages[index].Name)
-		if err != nil {
-			util.Display(
-				os.Stderr,
-				true,
-				"rpkgm was unable to get the information for the package %s to compare against new information. Error: %s",
+	// Get what the repo has before the sync
+	prevInfos, err := dbAdapter.GetAllPkgInfo()
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm couldn't read the repo's packages. Error: %s", err)
+		util.Exit(1)
+	}
+
+	prevInfosByName := make(map[string]database.PkgInfo, len(prevInfos))
+	for _, pkgInfo := range prevInfos {
+		prevInfosByName[pkgInfo.Name] = pkgInfo
+	}
+
+	var changes summary
+
+	isPublished := make(map[string]bool)
+
+	// Apply every change in a single transaction, so that the repo is never left half updated
+	err = dbAdapter.WithTx(func(txAdapter *database.Adapter) error {
+		for index := 0; index < len(pkgs.Packages); index++ {
+			isPublished[pkgs.Packages[index].Name] = true
+
+			pkgInfo, isKnown := prevInfosByName[pkgs.Packages[index].Name]
+
+			// A package that is new to the repo is added like an imported one
+			if !isKnown {
+				err := add.CheckPkg(&pkgs.Packages[index])
+				if err != nil {
+					util.Display(
+						os.Stderr,
+						true,
+						"rpkgm could not add %s to the repo, skipping... Error: %s",
+						pkgs.Packages[index].Name,
+						err,
+					)
+
+					continue
+				}
+
+				err = txAdapter.AddToRepo(
+					pkgs.Packages[index].Name,
+					pkgs.Packages[index].Description,
+					pkgs.Packages[index].Version,
+					pkgs.Packages[index].BuildFilesDir,
+					pkgs.Packages[index].ArchiveURL,
+					pkgs.Packages[index].Sha512,
+					pkgs.Packages[index].Dependencies,
+				)
+				if err != nil {
+					return fmt.Errorf("rpkgm was unable to add %s to the repo. Error: %w", pkgs.Packages[index].Name, err)
+				}
+
+				changes.added = append(changes.added, pkgs.Packages[index].Name)
+
+				continue
+			}
+
+			// If there isn't a description, give the previous one by default
+			if pkgs.Packages[index].Description == "" {
+				pkgs.Packages[index].Description = pkgInfo.Description
+			}
+
+			// If there isn't a build files dir, give the previous one by default
+			if pkgs.Packages[index].BuildFilesDir == "" {
+				pkgs.Packages[index].BuildFilesDir = pkgInfo.BuildFilesDir
+			}
+
+			// Remove any trailing /
+			pkgs.Packages[index].BuildFilesDir = strings.TrimSuffix(
+				pkgs.Packages[index].BuildFilesDir,
+				"/",
+			)
+
+			// If there isn't a dependencies list, give the previous one by default
+			if pkgs.Packages[index].Dependencies == "" {
+				pkgs.Packages[index].Dependencies = pkgInfo.Dependencies
+			}
+
+			// If the dependencies list is invalid, skip and print an error
+			if _, err := version.ParseConstraints(pkgs.Packages[index].Dependencies); err != nil {
+				util.Display(
+					os.Stderr,
+					true,
+					"rpkgm found an invalid dependencies list for %s, skipping... Error: %s",
+					pkgs.Packages[index].Name,
+					err,
+				)
+
+				continue
+			}
+
+			if isUnchanged(pkgInfo, pkgs.Packages[index]) {
+				continue
+			}
+
+			// Update the package in the repo
+			err := txAdapter.SyncRepo(
 				pkgs.Packages[index].Name,
-				err,
+				pkgs.Packages[index].Description,
+				pkgs.Packages[index].Version,
+				pkgs.Packages[index].BuildFilesDir,
+				pkgs.Packages[index].ArchiveURL,
+				pkgs.Packages[index].Sha512,
+				pkgs.Packages[index].Dependencies,
 			)
+			if err != nil {
+				return fmt.Errorf("rpkgm was unable to update %s in the repo. Error: %w", pkgs.Packages[index].Name, err)
+			}
 
-			continue
+			changes.updated = append(changes.updated, pkgs.Packages[index].Name)
 		}
 
-		// If there isn't a description, give the previous one by default
-		if pkgs.Packages[index].Description == "" {
-			pkgs.Packages[index].Description = pkgInfo.Description
-		}
+		// The packages the file doesn't have anymore aren't published by the repo anymore,
+		// the installed ones stay in the local database
+		for _, pkgInfo := range prevInfos {
+			if isPublished[pkgInfo.Name] {
+				continue
+			}
+
+			err := txAdapter.RemovePackage(pkgInfo.Name)
+			if err != nil {
+				return fmt.Errorf("rpkgm was unable to remove %s from the repo. Error: %w", pkgInfo.Name, err)
+			}
 
-		// If there isn't a build files dir, give the previous one by default
-		if pkgs.Packages[index].BuildFilesDir == "" {
-			pkgs.Packages[index].BuildFilesDir = pkgInfo.BuildFilesDir
+			changes.removed = append(changes.removed, pkgInfo.Name)
 		}
 
-		// Remove any trailing /
-		pkgs.Packages[index].BuildFilesDir = strings.TrimSuffix(
-			pkgs.Packages[index].BuildFilesDir,
-			"/",
-		)
+		return nil
+	})
+	if err != nil {
+		util.Display(os.Stderr, true, "%s, the repo was left as it was.", err)
+		util.Exit(1)
+	}
 
-		// If there isn't a repo version, give the previous one by default
-		if pkgs.Packages[index].Version == "" {
-			pkgs.Packages[index].Version = pkgInfo.RepoVersion
-		}
+	// Close the json file
+	err = jsonPkgFile.Close()
+	if err != nil {
+		util.Display(os.Stderr, true, "rpgkm couln't close the json file. Error: %s", err)
+		util.Exit(1)
+	}
 
-		// If there isn't an archive url, give the previous one by default
-		if pkgs.Packages[index].ArchiveURL == "" {
-			pkgs.Packages[index].ArchiveURL = pkgInfo.ArchiveURL
-		}
+	return changes
+}
+
+// syncRepo syncs a repository using a file, or using its remote if no file is given. Nothing is done if the remote's
+// files didn't change since the last sync, unless forced.
+func syncRepo(repo database.RepoLocation, importFile string, insecure, force bool) { //nolint:funlen,cyclop
+	var fetched []database.Fetched
 
-		// If there isn't an archive's hash, give the previous one by default
-		if pkgs.Packages[index].Sha512 == "" {
-			pkgs.Packages[index].Sha512 = pkgInfo.Sha512
+	if importFile == "" {
+		if repo.Source == "" {
+			util.Display(
+				os.Stderr,
+				true,
+				"rpkgm does not know where to sync %s from, give it a url or a remote in its [repo %s] section or use --url or --remote.",
+				repo.Name,
+				repo.Name,
+			)
+			util.Exit(1)
 		}
 
-		// If there isn't a dependencies list, give the previous one by default
-		if pkgs.Packages[index].Dependencies == "" {
-			pkgs.Packages[index].Dependencies = pkgInfo.Dependencies
+		importFile, fetched = dlFromRemote(repo, insecure, force)
+		if fetched == nil {
+			util.Display(os.Stdout, true, "%s: repo up to date.", repo.Name)
+
+			return
 		}
+	} else {
+		// A given file is signed like the remote's one, the serial accepted for it is recorded as it would be by a fetch
+		file := filepath.Base(importFile)
+		serial := checkSignature(importFile, repo.Name, lastFetched(repo, []string{file})[file].Serial, insecure)
+		fetched = []database.Fetched{{File: file, Serial: serial}}
+	}
 
-		// Add the package to the repo
-		err = dbAdapter.SyncRepo(
-			pkgs.Packages[index].Name,
-			pkgs.Packages[index].Description,
-			pkgs.Packages[index].Version,
-			pkgs.Packages[index].BuildFilesDir,
-			pkgs.Packages[index].ArchiveURL,
-			pkgs.Packages[index].Sha512,
-			pkgs.Packages[index].Dependencies,
+	var isNew bool
+
+	if _, err := os.Stat(repo.Path); errors.Is(err, os.ErrNotExist) {
+		isNew = true
+	}
+
+	// Create the directory of the database if it's the first time the repo is synced
+	err := os.MkdirAll(filepath.Dir(repo.Path), os.ModePerm)
+	if err != nil {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not create the directory of the database of %s. Error: %s",
+			repo.Name,
+			err,
+		)
+		util.Exit(1)
+	}
+
+	// The repo is synced in a copy of its database which replaces it once complete,
+	// so that the database is never left half synced and what is installed, kept in the local database, is never touched
+	tmpPath := repo.Path + ".new"
+
+	// Leftover of an interrupted sync
+	err = os.Remove(tmpPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not remove the leftover of a previous sync of %s. Error: %s",
+			repo.Name,
+			err,
 		)
+		util.Exit(1)
+	}
+
+	// A repo synced for the first time starts from an empty database
+	if !isNew {
+		err = util.Copy(repo.Path, tmpPath, false)
 		if err != nil {
 			util.Display(
-				os.Stderr, true,
-				"rpkgm was unable to update %s in the repo. Error: %s",
-				pkgs.Packages[index].Name,
+				os.Stderr,
+				true,
+				"rpkgm could not copy the database of %s to sync it. Error: %s",
+				repo.Name,
 				err,
 			)
+			util.Exit(1)
 		}
 	}
 
-	// Close the json file
-	err = jsonPkgFile.Close()
+	// Connect to the database
+	dbAdapter, err := database.NewAdapter("sqlite3", tmpPath)
 	if err != nil {
-		util.Display(os.Stderr, true, "rpgkm couln't close the json file. Error: %s", err)
-		os.Exit(1)
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not connect to the database. Error: %s",
+			err,
+		)
+		util.Exit(1)
 	}
-}
 
-// Decide decides what to do based on the given strings.
-func Decide(repoDB, importFile, remote, repoName string) {
-	if importFile == "" {
-		importFile = dlFromRemote(remote, repoName)
-	}
+	changes := syncWithFile(importFile, dbAdapter)
 
-	var doAdd bool
+	// Remember what was fetched so that the next sync only downloads what changed, a repo synced with a given file
+	// doesn't match its remote's files anymore but the serials of the signatures accepted are kept
+	err = dbAdapter.ClearFetched()
+	for _, file := range fetched {
+		err = errors.Join(err, dbAdapter.SetFetched(file))
+	}
 
-	if _, err := os.Stat(repoDB); errors.Is(err, os.ErrNotExist) {
-		doAdd = true
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not record what was fetched for %s. Error: %s", repo.Name, err)
+		util.Exit(1)
 	}
 
-	// Connect to the database
-	dbAdapter, err := database.NewAdapter("sqlite3", repoDB)
+	// Close the database connection
+	err = dbAdapter.CloseDBConnection()
 	if err != nil {
 		util.Display(
-			os.Stderr,
-			true,
-			"rpkgm could not connect to the database. Error: %s",
+			os.Stderr, true,
+			"rpkgm could not close the connection to the database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
-	// Create the packages table if it does not exist
-	err = dbAdapter.CreatePkgTable()
+	// Replace the database with the synced one
+	err = os.Rename(tmpPath, repo.Path)
 	if err != nil {
 		util.Display(
 			os.Stderr,
 			true,
-			"rpkgm could not create the packages table in the repo. Error: %s",
+			"rpkgm could not replace the database of %s with the synced one. Error: %s",
+			repo.Name,
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
-	if doAdd {
-		add.ImportPkgs(importFile, dbAdapter)
-	} else {
-		syncWithFile(importFile, dbAdapter)
+	util.Display(
+		os.Stdout,
+		true,
+		"%s: %s, %s, %s.",
+		repo.Name,
+		count(changes.added, "added"),
+		count(changes.updated, "updated"),
+		count(changes.removed, "removed"),
+	)
+}
+
+// reportForeign shows the installed packages that no repository has anymore, they can't be updated nor reinstalled.
+func reportForeign(dbLocation database.Location) {
+	// Connect to the database
+	store, err := database.Open(dbLocation)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not connect to the database. Error: %s", err)
+		util.Exit(1)
+	}
+
+	pkgInfos, err := store.GetInstalledPkgInfo()
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not get the installed packages. Error: %s", err)
+		util.Exit(1)
+	}
+
+	var foreign []string
+
+	for _, pkgInfo := range pkgInfos {
+		if pkgInfo.IsForeign() {
+			foreign = append(foreign, pkgInfo.Name)
+		}
+	}
+
+	if len(foreign) > 0 {
+		util.Display(
+			os.Stdout,
+			true,
+			"Installed but in no repository anymore (foreign): %s",
+			strings.Join(foreign, ", "),
+		)
 	}
 
 	// Close the database connection
-	err = dbAdapter.CloseDBConnection()
+	err = store.CloseDBConnection()
 	if err != nil {
 		util.Display(
 			os.Stderr, true,
 			"rpkgm could not close the connection to the database. Error: %s",
 			err,
 		)
-		os.Exit(1)
+		util.Exit(1)
 	}
 }
+
+// Decide syncs the given repositories, in order. When a file is given, it is the one the repository is synced with.
+// The installed packages that are in none of the configured repositories afterward are reported.
+// Unless insecure, the repositories' files have to be signed by a key of the Keyring. Unless forced, the repositories
+// whose files didn't change since their last sync are left as they are.
+func Decide(dbLocation database.Location, repos []database.RepoLocation, importFile string, insecure, force bool) {
+	for _, repo := range repos {
+		util.Display(os.Stdout, false, "Syncing %s...", repo.Name)
+		syncRepo(repo, importFile, insecure, force)
+	}
+
+	reportForeign(dbLocation)
+}
@@ -0,0 +1,380 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package transaction
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/stage"
+)
+
+// Operations a transaction performs on its packages.
+const (
+	OpInstall   = "install"
+	OpUninstall = "uninstall"
+)
+
+// modeMask keeps the permission bits along with setuid, setgid and sticky, like the recorded modes.
+const modeMask = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// Transaction defines a journaled operation on a list of packages, which can be rolled back as a whole.
+type Transaction struct {
+	database.Transaction
+	Steps []database.JournalStep
+	store *database.Store
+}
+
+// Step is the handle a step of a transaction uses to save what it is about to change.
+type Step struct {
+	txn *Transaction
+	seq int
+}
+
+// Begin records a new transaction performing an operation on the given packages, in order. Packages to install are
+// qualified by the repository they are installed from (ex: extra/foo). Reasons holds the reason each package is
+// installed for. The files the transaction replaces are saved under stateDir.
+func Begin(
+	store *database.Store,
+	operation, stateDir string,
+	packages []string,
+	reasons map[string]string,
+) (*Transaction, error) {
+	steps := make([]database.JournalStep, 0, len(packages))
+	for seq, pkgName := range packages {
+		repo, name := database.SplitName(pkgName)
+		steps = append(steps, database.JournalStep{Seq: seq, Package: name, Repo: repo, Reason: reasons[pkgName]})
+	}
+
+	txID, err := store.BeginTransaction(operation, time.Now().Format(time.RFC3339), steps)
+	if err != nil {
+		return nil, err
+	}
+
+	backupDir, err := filepath.Abs(filepath.Join(stateDir, "transactions", strconv.FormatInt(txID, 10)))
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.SetTransactionBackupDir(txID, backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return Unfinished(store)
+}
+
+// Unfinished returns the transaction that was interrupted, nil is returned if there is none.
+func Unfinished(store *database.Store) (*Transaction, error) {
+	dbTxn, err := store.GetUnfinishedTransaction()
+	if err != nil || dbTxn == nil {
+		return nil, err
+	}
+
+	steps, err := store.GetJournalSteps(dbTxn.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transaction{Transaction: *dbTxn, Steps: steps, store: store}, nil
+}
+
+// Start records the state of a step's package before the step changes it and returns the package's information.
+// If the step was interrupted while being applied, what it did is rolled back first so that it can be applied again
+// from scratch.
+func (txn *Transaction) Start(seq int, root string) (database.PkgInfo, error) {
+	if txn.Steps[seq].State == database.StepApplying {
+		err := txn.rollbackStep(seq, root)
+		if err != nil {
+			return database.PkgInfo{}, err
+		}
+	}
+
+	step := txn.Steps[seq]
+
+	info, err := txn.store.GetPkgInfo(database.JoinName(step.Repo, step.Package))
+	if err != nil {
+		return database.PkgInfo{}, err
+	}
+
+	manifest, err := txn.store.GetManifest(info.Name)
+	if err != nil {
+		return database.PkgInfo{}, err
+	}
+
+	installed, err := txn.store.GetInstalled(info.Name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return database.PkgInfo{}, err
+	}
+
+	step.State = database.StepApplying
+	step.PrevInstalled = info.Installed
+	step.PrevVersion = installed.Version
+	step.PrevReason = installed.Reason
+	step.PrevRepo = installed.Repo
+	step.PrevDependencies = installed.Dependencies
+	step.PrevInstallDate = installed.Date
+
+	err = txn.store.StartStep(step, manifest)
+	if err != nil {
+		return database.PkgInfo{}, err
+	}
+
+	txn.Steps[seq] = step
+
+	return info, nil
+}
+
+// Step returns the handle of a step.
+func (txn *Transaction) Step(seq int) Step {
+	return Step{txn: txn, seq: seq}
+}
+
+// Finish marks a step as done.
+func (txn *Transaction) Finish(seq int) error {
+	err := txn.store.SetStepState(txn.ID, seq, database.StepDone)
+	if err != nil {
+		return err
+	}
+
+	txn.Steps[seq].State = database.StepDone
+
+	return nil
+}
+
+// Commit marks the transaction as done and removes the saved files.
+func (txn *Transaction) Commit() error {
+	err := txn.store.SetTransactionState(txn.ID, database.TxDone)
+	if err != nil {
+		return err
+	}
+
+	return txn.cleanup()
+}
+
+// Rollback rolls back every started step, the last one first, and marks the transaction as rolled back.
+func (txn *Transaction) Rollback(root string) error {
+	for seq := len(txn.Steps) - 1; seq >= 0; seq-- {
+		if txn.Steps[seq].State == database.StepPending {
+			continue
+		}
+
+		err := txn.rollbackStep(seq, root)
+		if err != nil {
+			return fmt.Errorf("could not roll back %s: %w", txn.Steps[seq].Package, err)
+		}
+	}
+
+	err := txn.store.SetTransactionState(txn.ID, database.TxRolledBack)
+	if err != nil {
+		return err
+	}
+
+	return txn.cleanup()
+}
+
+// rollbackStep puts back the files a step changed along with its package's state and marks the step as pending.
+func (txn *Transaction) rollbackStep(seq int, root string) error {
+	paths, err := txn.store.GetJournalPaths(txn.ID, seq)
+	if err != nil {
+		return err
+	}
+
+	err = restorePaths(root, txn.stepDir(seq), paths)
+	if err != nil {
+		return err
+	}
+
+	err = txn.store.RestoreStep(txn.Steps[seq])
+	if err != nil {
+		return err
+	}
+
+	err = txn.store.RemoveJournalPaths(txn.ID, seq)
+	if err != nil {
+		return err
+	}
+
+	err = txn.store.SetStepState(txn.ID, seq, database.StepPending)
+	if err != nil {
+		return err
+	}
+
+	txn.Steps[seq].State = database.StepPending
+
+	return os.RemoveAll(txn.stepDir(seq))
+}
+
+// cleanup forgets the saved paths of the transaction and removes its backup directory.
+func (txn *Transaction) cleanup() error {
+	err := txn.store.ClearJournal(txn.ID)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(txn.BackupDir)
+}
+
+// stepDir returns the directory the files replaced by a step are saved into.
+func (txn *Transaction) stepDir(seq int) string {
+	return filepath.Join(txn.BackupDir, strconv.Itoa(seq))
+}
+
+// Backup saves the state of the given paths before the step changes them, paths already saved by the step are
+// skipped. Files and symlinks are copied to the backup directory, paths that don't exist are recorded as absent.
+func (step Step) Backup(root string, entries []database.ManifestEntry) error {
+	txn := step.txn
+	stepDir := txn.stepDir(step.seq)
+
+	saved, err := txn.store.GetJournalPaths(txn.ID, step.seq)
+	if err != nil {
+		return err
+	}
+
+	isSaved := make(map[string]bool, len(saved))
+	for _, path := range saved {
+		isSaved[path.Path] = true
+	}
+
+	idx := len(saved)
+
+	for _, entry := range entries {
+		if isSaved[entry.Path] {
+			continue
+		}
+		isSaved[entry.Path] = true
+
+		path := database.JournalPath{TxID: txn.ID, Seq: step.seq, Idx: idx, Path: entry.Path}
+
+		info, err := os.Lstat(filepath.Join(root, entry.Path))
+
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// Remember what kind of path will be created, so that a directory is only removed if it's empty
+			path.State = database.PathAbsent
+			path.Type = entry.Type
+		case err != nil:
+			return err
+		default:
+			path.State = database.PathSaved
+			path.Mode = uint32(info.Mode() & modeMask)
+
+			switch {
+			case info.IsDir():
+				path.Type = database.EntryDir
+			case info.Mode().IsRegular():
+				path.Type = database.EntryFile
+			case info.Mode()&fs.ModeSymlink != 0:
+				path.Type = database.EntrySymlink
+
+				path.Target, err = os.Readlink(filepath.Join(root, entry.Path))
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("%w: %s (%s)", stage.ErrUnsupportedType, entry.Path, info.Mode().Type())
+			}
+
+			err = savePath(root, stepDir, path)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = txn.store.AddJournalPath(path)
+		if err != nil {
+			return err
+		}
+		idx++
+	}
+
+	return nil
+}
+
+// savePath copies a file or a symlink from the root into the backup directory, directories only need their mode.
+func savePath(root, stepDir string, path database.JournalPath) error {
+	if path.Type == database.EntryDir {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(filepath.Join(stepDir, path.Path)), 0o700) //nolint:gomnd
+	if err != nil {
+		return err
+	}
+
+	// The root is merged into the backup directory
+	return stage.Merge(root, stepDir, []database.ManifestEntry{toEntry(path)})
+}
+
+// restorePaths puts the root back in the state recorded by the given paths.
+func restorePaths(root, stepDir string, paths []database.JournalPath) error {
+	// Remove what didn't exist before, children before their parents
+	for idx := len(paths) - 1; idx >= 0; idx-- {
+		if paths[idx].State != database.PathAbsent {
+			continue
+		}
+
+		dst := filepath.Join(root, paths[idx].Path)
+
+		info, err := os.Lstat(dst)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Directories that aren't empty hold something else, keep them
+		if info.IsDir() {
+			_ = os.Remove(dst)
+
+			continue
+		}
+
+		err = os.Remove(dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Put back what was saved, parents before their children
+	for _, path := range paths {
+		if path.State != database.PathSaved {
+			continue
+		}
+
+		err := stage.Merge(stepDir, root, []database.ManifestEntry{toEntry(path)})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toEntry converts a saved path to the manifest entry stage.Merge expects.
+func toEntry(path database.JournalPath) database.ManifestEntry {
+	return database.ManifestEntry{Path: path.Path, Type: path.Type, Mode: path.Mode, Target: path.Target}
+}
@@ -0,0 +1,307 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package transaction_test
+
+import (
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/transaction"
+)
+
+// tree describes the paths under a root, relative to it: directories end with a slash, the value of a symlink is its
+// target prefixed by "->" and the value of a file is its content.
+type tree map[string]string
+
+// sorted returns the paths of a tree, parents first.
+func (paths tree) sorted() []string {
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	slices.Sort(sorted)
+
+	return sorted
+}
+
+// write creates the paths of a tree under a root, replacing what's there.
+func (paths tree) write(t *testing.T, root string) {
+	t.Helper()
+
+	for _, path := range paths.sorted() {
+		dst := filepath.Join(root, path)
+
+		err := os.MkdirAll(filepath.Dir(dst), 0o755)
+		if err == nil && strings.HasSuffix(path, "/") {
+			err = os.MkdirAll(dst, 0o755)
+		} else if err == nil {
+			_ = os.Remove(dst)
+
+			if target, isLink := strings.CutPrefix(paths[path], "->"); isLink {
+				err = os.Symlink(target, dst)
+			} else {
+				err = os.WriteFile(dst, []byte(paths[path]), 0o644)
+			}
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// manifest returns the manifest entries of the paths of a tree, parents first.
+func (paths tree) manifest() []database.ManifestEntry {
+	entries := make([]database.ManifestEntry, 0, len(paths))
+
+	for _, path := range paths.sorted() {
+		entry := database.ManifestEntry{Path: "/" + strings.TrimSuffix(path, "/"), Type: database.EntryFile}
+
+		if strings.HasSuffix(path, "/") {
+			entry.Type = database.EntryDir
+		} else if target, isLink := strings.CutPrefix(paths[path], "->"); isLink {
+			entry.Type, entry.Target = database.EntrySymlink, target
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// readTree returns the tree of what is under a root.
+func readTree(t *testing.T, root string) tree {
+	t.Helper()
+
+	paths := tree{}
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+
+		switch {
+		case entry.IsDir():
+			paths[rel+"/"] = ""
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			paths[rel] = "->" + target
+
+			return err
+		default:
+			content, err := os.ReadFile(path)
+			paths[rel] = string(content)
+
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return paths
+}
+
+// openStore opens a store whose only repository has the package foo.
+func openStore(t *testing.T) *database.Store {
+	t.Helper()
+
+	dir := t.TempDir()
+	repoPath := filepath.Join(dir, "main.db")
+
+	repoAdapter, err := database.NewAdapter("sqlite3", repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repoAdapter.AddToRepo("foo", "foo", "1.0", "foo", "https://example.com/foo.tar.gz", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repoAdapter.CloseDBConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := database.Open(database.Location{
+		Local: filepath.Join(dir, "local.db"),
+		Repos: []database.RepoLocation{{Name: "main", Path: repoPath}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = store.CloseDBConnection() })
+
+	return store
+}
+
+// setInstalled records foo as installed at a version with the given manifest.
+func setInstalled(t *testing.T, store *database.Store, version string, manifest []database.ManifestEntry) {
+	t.Helper()
+
+	err := store.SetInstalled(database.InstalledPkg{Name: "foo", Version: version, Repo: "main"})
+	if err == nil {
+		err = store.SetManifest("foo", manifest)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollback(t *testing.T) { //nolint:funlen,cyclop
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		// before is what the root holds before the transaction, installed tells if foo is installed with it as
+		// its manifest
+		before    tree
+		installed bool
+		// installs is what the step installs, others is what something else creates meanwhile
+		installs tree
+		others   tree
+		// want is what the root holds once rolled back, before if it's nil
+		want tree
+	}{
+		{
+			name:      "replaced file",
+			before:    tree{"etc/": "", "etc/foo.conf": "old"},
+			installed: true,
+			installs:  tree{"etc/": "", "etc/foo.conf": "new"},
+		},
+		{
+			name:     "created files and directories",
+			before:   tree{"etc/": ""},
+			installs: tree{"etc/": "", "etc/foo.conf": "new", "usr/": "", "usr/bin/": "", "usr/bin/foo": "#!/bin/sh"},
+		},
+		{
+			name: "replaced symlink",
+			before: tree{
+				"usr/":                "",
+				"usr/lib/":            "",
+				"usr/lib/libfoo.so":   "->libfoo.so.1",
+				"usr/lib/libfoo.so.1": "1",
+			},
+			installed: true,
+			installs:  tree{"usr/": "", "usr/lib/": "", "usr/lib/libfoo.so": "->libfoo.so.2", "usr/lib/libfoo.so.2": "2"},
+		},
+		{
+			name:     "created directory holding something else",
+			before:   tree{},
+			installs: tree{"opt/": "", "opt/foo/": "", "opt/foo/foo": "foo"},
+			others:   tree{"opt/bar": "bar"},
+			want:     tree{"opt/": "", "opt/bar": "bar"},
+		},
+	}
+
+	for _, test := range tests {
+		root := t.TempDir()
+		store := openStore(t)
+
+		test.before.write(t, root)
+
+		if test.installed {
+			setInstalled(t, store, "0.9", test.before.manifest())
+		}
+
+		txn, err := transaction.Begin(store, transaction.OpInstall, t.TempDir(), []string{"main/foo"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = txn.Start(0, root)
+		if err == nil {
+			err = txn.Step(0).Backup(root, test.installs.manifest())
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		test.installs.write(t, root)
+		test.others.write(t, root)
+		setInstalled(t, store, "1.0", test.installs.manifest())
+
+		// Roll back as rpkgm does after being interrupted, from what the journal holds
+		unfinished, err := transaction.Unfinished(store)
+		if err != nil || unfinished == nil {
+			t.Fatalf("%s: Unfinished returned %v, %v", test.name, unfinished, err)
+		}
+
+		err = unfinished.Rollback(root)
+		if err != nil {
+			t.Errorf("%s: Rollback returned an error: %s", test.name, err)
+
+			continue
+		}
+
+		want := test.want
+		if want == nil {
+			want = test.before
+		}
+
+		if got := readTree(t, root); !maps.Equal(got, want) {
+			t.Errorf("%s: the root holds %v once rolled back, want %v", test.name, got, want)
+		}
+
+		info, err := store.GetPkgInfo("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Installed != test.installed || (test.installed && info.InstalledVersion != "0.9") {
+			t.Errorf("%s: foo is installed: %t at %q once rolled back, want %t at 0.9",
+				test.name, info.Installed, info.InstalledVersion, test.installed)
+		}
+
+		manifest, err := store.GetManifest("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantManifest := 0
+		if test.installed {
+			wantManifest = len(test.before)
+		}
+
+		if len(manifest) != wantManifest {
+			t.Errorf("%s: foo's manifest has %d entries once rolled back, want %d",
+				test.name, len(manifest), wantManifest)
+		}
+
+		if _, err = os.Stat(unfinished.BackupDir); err == nil {
+			t.Errorf("%s: the backup directory %s is left once rolled back", test.name, unfinished.BackupDir)
+		}
+
+		if unfinished, err = transaction.Unfinished(store); err != nil || unfinished != nil {
+			t.Errorf("%s: Unfinished returned %v, %v once rolled back, want nothing", test.name, unfinished, err)
+		}
+	}
+}
@@ -1,28 +1,99 @@
 package update
 
 import (
+	"database/sql"
+	"errors"
 	"os"
+	"path/filepath"
 
 	"github.com/redds-be/rpkgm/internal/database"
 	"github.com/redds-be/rpkgm/internal/pkg"
+	"github.com/redds-be/rpkgm/internal/transaction"
 	"github.com/redds-be/rpkgm/internal/util"
+	"github.com/redds-be/rpkgm/internal/version"
 )
 
+// installedFrom returns the information about an installed package taken from the repository it was installed from, so
+// that it's never switched to another repository having the same package. It has no repository, and so nothing to be
+// updated to, if that repository doesn't have it anymore.
+func installedFrom(store *database.Store, pkgInfo database.PkgInfo) (database.PkgInfo, error) {
+	if pkgInfo.InstalledRepo == "" || pkgInfo.InstalledRepo == pkgInfo.Repo {
+		return pkgInfo, nil
+	}
+
+	info, err := store.GetPkgInfo(database.JoinName(pkgInfo.InstalledRepo, pkgInfo.Name))
+	if errors.Is(err, sql.ErrNoRows) {
+		pkgInfo.Repo, pkgInfo.RepoVersion = "", ""
+
+		return pkgInfo, nil
+	}
+
+	return info, err
+}
+
+// direction compares a package's installed version against its repo version.
+// It returns 1 for an upgrade, -1 for a downgrade and 0 if there is nothing to do, along with a word to describe it.
+func direction(pkgInfo database.PkgInfo) (int, string, error) {
+	// A package that is in no repository anymore has nothing to be updated to
+	if pkgInfo.Repo == "" {
+		return 0, "none", nil
+	}
+
+	res, err := version.Compare(pkgInfo.RepoVersion, pkgInfo.InstalledVersion)
+	if err != nil {
+		return 0, "", err
+	}
+
+	switch res {
+	case 1:
+		return res, "upgrade", nil
+	case -1:
+		return res, "downgrade", nil
+	default:
+		return res, "none", nil
+	}
+}
+
 // checkUpdate checks every installed package to see if there's an update (only informative).
 func checkUpdate(installPkgsInfo []database.PkgInfo) {
 	var isThereAnUpdate bool
-	// For each installed, package, check if the repo's version if different and inform the user
+	// For each installed, package, compare the repo's version against the installed one and inform the user
 	for _, pkgInfo := range installPkgsInfo {
-		if pkgInfo.InstalledVersion != pkgInfo.RepoVersion {
+		res, dir, err := direction(pkgInfo)
+		if err != nil {
+			util.Display(
+				os.Stderr,
+				true,
+				"rpkgm could not compare the versions of %s. Error: %s",
+				pkgInfo.Name,
+				err,
+			)
+
+			continue
+		}
+
+		switch res {
+		case 1:
 			util.Display(
 				os.Stdout,
 				false,
-				"Update available for %s, Current version: %s | New version: %s",
+				"Update available for %s, Current version: %s | New version: %s (%s)",
 				pkgInfo.Name,
 				pkgInfo.InstalledVersion,
 				pkgInfo.RepoVersion,
+				dir,
 			)
 			isThereAnUpdate = true
+		case -1:
+			util.Display(
+				os.Stdout,
+				false,
+				"The repo's version of %s is older than the installed one, Current version: %s | Repo's version: %s (%s)",
+				pkgInfo.Name,
+				pkgInfo.InstalledVersion,
+				pkgInfo.RepoVersion,
+				dir,
+			)
 		}
 	}
 
@@ -34,22 +105,25 @@ func checkUpdate(installPkgsInfo []database.PkgInfo) {
 
 // Decide decides what to do based on the booleans.
 func Decide( //nolint:funlen,gocognit,cyclop
-	repoDB string,
+	dbLocation database.Location,
 	packageList []string,
-	all, check, verbose, yes, keep bool,
+	all, check, verbose, yes, keep, downgrade bool,
 ) {
 	// Connect to the database
-	dbAdapter, err := database.NewAdapter("sqlite3", repoDB)
+	store, err := database.Open(dbLocation)
 	if err != nil {
 		util.Display(os.Stderr, true, "rpkgm could not connect to the database. Error: %s", err)
-		os.Exit(1)
+		util.Exit(1)
 	}
 
+	// Refuse to do anything while a previous transaction is unfinished
+	pkg.CheckInterrupted(store)
+
 	// If we check or we want to update every packages, get their info
 	var installedPkgsInfo []database.PkgInfo
 	if check || all {
 		// Get every installed packages's general information
-		installedPkgsInfo, err = dbAdapter.GetInstalledPkgInfo()
+		installedPkgsInfo, err = store.GetInstalledPkgInfo()
 		if err != nil {
 			util.Display(
 				os.Stderr,
@@ -58,12 +132,41 @@ func Decide( //nolint:funlen,gocognit,cyclop
 				err,
 			)
 		}
+
+		// Every package is compared against the repository it was installed from
+		for index, pkgInfo := range installedPkgsInfo {
+			installedPkgsInfo[index], err = installedFrom(store, pkgInfo)
+			if err != nil {
+				util.Display(
+					os.Stderr,
+					true,
+					"rpkgm could not get %s's general information. Error: %s",
+					pkgInfo.Name,
+					err,
+				)
+				util.Exit(1)
+			}
+		}
 	}
 
 	// If we update every package, append packages that have an update to packageList
+	// (and packages that would be downgraded if we're asked to)
 	if all {
 		for _, pkgInfo := range installedPkgsInfo {
-			if pkgInfo.InstalledVersion != pkgInfo.RepoVersion {
+			res, _, err := direction(pkgInfo)
+			if err != nil {
+				util.Display(
+					os.Stderr,
+					true,
+					"rpkgm could not compare the versions of %s. Error: %s",
+					pkgInfo.Name,
+					err,
+				)
+
+				continue
+			}
+
+			if res == 1 || (res == -1 && downgrade) {
 				packageList = append(packageList, pkgInfo.Name)
 			}
 		}
@@ -72,9 +175,9 @@ func Decide( //nolint:funlen,gocognit,cyclop
 	// If packageList isn't empty, check if they are installed, get their info,
 	// ask before updating, and update them
 	if len(packageList) > 0 { //nolint:nestif
-		for index, pkgName := range packageList {
+		for _, pkgName := range packageList {
 			// Check if the package is installed
-			isInstalled, err := dbAdapter.IsInstalled(pkgName)
+			isInstalled, err := store.IsInstalled(pkgName)
 			if err != nil {
 				util.Display(
 					os.Stderr,
@@ -83,7 +186,7 @@ func Decide( //nolint:funlen,gocognit,cyclop
 					pkgName,
 					err,
 				)
-				os.Exit(1)
+				util.Exit(1)
 			}
 
 			// If it isn't installed, skip
@@ -98,8 +201,12 @@ func Decide( //nolint:funlen,gocognit,cyclop
 				continue
 			}
 
-			// Get the package's general info
-			pkgInfo, err := dbAdapter.GetPkgInfo(pkgName)
+			// Get the package's general info, from the repository it was installed from
+			pkgInfo, err := store.GetPkgInfo(pkgName)
+			if err == nil {
+				pkgInfo, err = installedFrom(store, pkgInfo)
+			}
+
 			if err != nil {
 				util.Display(
 					os.Stderr,
@@ -108,18 +215,47 @@ func Decide( //nolint:funlen,gocognit,cyclop
 					pkgName,
 					err,
 				)
-				os.Exit(1)
+				util.Exit(1)
+			}
+
+			// Compare the installed version against the repo's version
+			res, dir, err := direction(pkgInfo)
+			if err != nil {
+				util.Display(
+					os.Stderr,
+					true,
+					"rpkgm could not compare the versions of %s. Error: %s",
+					pkgName,
+					err,
+				)
+
+				continue
+			}
+
+			// Refuse to downgrade unless we're asked to
+			if res == -1 && !downgrade {
+				util.Display(
+					os.Stderr,
+					true,
+					"The repo's version of %s (%s) is older than the installed one (%s), you can downgrade it by re-running with --downgrade. Skipping...",
+					pkgName,
+					pkgInfo.RepoVersion,
+					pkgInfo.InstalledVersion,
+				)
+
+				continue
 			}
 
-			// If its installed and if there's an update, update it
-			if isInstalled && pkgInfo.InstalledVersion != pkgInfo.RepoVersion {
+			// If its installed and if there's an update (or a wanted downgrade), update it
+			if res != 0 {
 				util.Display(
 					os.Stdout,
 					true,
-					"Updating %s from version %s to version %s.",
-					pkgInfo.Name,
+					"Updating %s from version %s to version %s (%s).",
+					pkgInfo.FullName(),
 					pkgInfo.InstalledVersion,
 					pkgInfo.RepoVersion,
+					dir,
 				)
 
 				// Append to the marked packages
@@ -127,24 +263,25 @@ func Decide( //nolint:funlen,gocognit,cyclop
 
 				// Ask
 				if !yes {
-					pkg.Ask(dbAdapter)
+					pkg.Ask(store)
 				}
 
-				// Install
-				err = pkg.Install(
-					pkgInfo,
-					index+1,
-					len(packageList),
-					verbose,
-					keep,
-					false,
-					dbAdapter,
+				// Install the new version in its own transaction, so that a failure leaves the old version in place
+				txn, err := transaction.Begin(
+					store,
+					transaction.OpInstall,
+					filepath.Dir(dbLocation.Local),
+					[]string{pkgInfo.FullName()},
+					map[string]string{pkgInfo.FullName(): pkgInfo.InstallReason},
 				)
 				if err != nil {
-					util.Display(os.Stderr, true, "%s", err)
+					util.Display(os.Stderr, true, "rpkgm could not start the transaction. Error: %s", err)
 
 					continue
 				}
+
+				// Errors are displayed by Apply, a failed update doesn't prevent the other ones
+				_ = pkg.Apply(txn, verbose, keep, false, nil, store)
 			} else {
 				util.Display(os.Stdout, true, "No updates available for %s.", pkgName)
 			}
@@ -159,7 +296,7 @@ func Decide( //nolint:funlen,gocognit,cyclop
 	}
 
 	// Close the connection to the database
-	err = dbAdapter.CloseDBConnection()
+	err = store.CloseDBConnection()
 	if err != nil {
 		util.Display(
 			os.Stderr,
@@ -29,6 +29,8 @@ import (
 	"net/http"
 	"os"
 	"os/user"
+	"strings"
+	"time"
 
 	"github.com/redds-be/rpkgm/internal/logging"
 )
@@ -40,17 +42,36 @@ var (
 	Bg = "\033[1m\033[32m"
 )
 
+// exitHooks are the functions Exit runs before rpkgm exits.
+var exitHooks []func()
+
+// OnExit registers a function for Exit to run before rpkgm exits (ex: releasing its lock), the last one registered
+// runs first.
+func OnExit(hook func()) {
+	exitHooks = append(exitHooks, hook)
+}
+
+// Exit runs the functions registered with OnExit and exits with the given status code. rpkgm exits through it, as
+// os.Exit skips them like it skips deferred calls.
+func Exit(code int) {
+	for idx := len(exitHooks) - 1; idx >= 0; idx-- {
+		exitHooks[idx]()
+	}
+
+	os.Exit(code) //nolint:forbidigo
+}
+
 // CheckRoot checks if the user is root.
 func CheckRoot(message string) {
 	currUser, err := user.Current()
 	if err != nil {
 		Display(os.Stderr, true, "Unable to determine if rpkgm is running as root. Error: %s", err)
-		os.Exit(1)
+		Exit(1)
 	}
 
 	if currUser.Uid != "0" {
 		Display(os.Stderr, false, message)
-		os.Exit(1)
+		Exit(1)
 	}
 }
 
@@ -66,8 +87,38 @@ func Display(out io.Writer, doLog bool, format string, toDisplay ...any) {
 	}
 }
 
-// Download downloads a body from a url and writes to dest.
+// Download settings, a timeout of 0 means no timeout.
+var (
+	DownloadTimeout time.Duration
+	DownloadRetries int
+)
+
+// IsDownloadable checks if Download can fetch a url, which is the case of http://, https:// and file:// urls.
+func IsDownloadable(url string) bool {
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "file://")
+}
+
+// Download downloads a body from a url and writes to dest, it's retried DownloadRetries times if it fails.
+// A file:// url is copied from the local file system (ex: a mounted mirror).
 func Download(dest, url string) error {
+	if path, isLocal := strings.CutPrefix(url, "file://"); isLocal {
+		return Copy(path, dest, true)
+	}
+
+	var err error
+
+	for attempt := 0; attempt <= DownloadRetries; attempt++ {
+		err = download(dest, url)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// download downloads a body from a url and writes to dest.
+func download(dest, url string) error {
 	// Create the destination file
 	destFile, err := os.Create(dest)
 	if err != nil {
@@ -75,32 +126,36 @@ func Download(dest, url string) error {
 	}
 
 	// Prepare the client and the request
-	client := &http.Client{}
+	client := &http.Client{Timeout: DownloadTimeout}
 	ctx := context.Background()
 	dlReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return errors.Join(err, destFile.Close())
 	}
 
 	// Do the request
 	resp, err := client.Do(dlReq)
 	if err != nil {
-		return err
+		return errors.Join(err, destFile.Close())
 	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("url returned code %d", resp.StatusCode) //nolint:goerr113
+		return errors.Join(
+			fmt.Errorf("url returned code %d", resp.StatusCode), //nolint:goerr113
+			resp.Body.Close(),
+			destFile.Close(),
+		)
 	}
 
 	// Copy the content of the body to the newly created file
 	_, err = io.Copy(destFile, resp.Body)
 	if err != nil {
-		return err
+		return errors.Join(err, resp.Body.Close(), destFile.Close())
 	}
 
 	// Close the destination file
 	err = destFile.Close()
 	if err != nil {
-		return err
+		return errors.Join(err, resp.Body.Close())
 	}
 
 	// Close the body
@@ -109,27 +164,136 @@ func Download(dest, url string) error {
 	return err
 }
 
-// Verify verifies the sha512 hash of a file.
-func Verify(fileToVerify, supposedHash string) (bool, error) {
-	// Open the file to verify
-	file, err := os.Open(fileToVerify)
+// Validators defines what a server says identifies the version of a file, its ETag and Last-Modified headers.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// DownloadIfChanged downloads a url to dest like Download, unless the server says the file still has the given
+// validators, in which case dest is left as it is and false is returned. The file's validators are returned along.
+// A file:// url has no validators, it is always copied.
+func DownloadIfChanged(dest, url string, validators Validators) (Validators, bool, error) {
+	if strings.HasPrefix(url, "file://") {
+		return Validators{}, true, Download(dest, url)
+	}
+
+	// Without a previous download there is nothing to keep
+	if _, err := os.Stat(dest); err != nil {
+		validators = Validators{}
+	}
+
+	var err error
+
+	for attempt := 0; attempt <= DownloadRetries; attempt++ {
+		var (
+			newValidators Validators
+			isChanged     bool
+		)
+
+		newValidators, isChanged, err = downloadIfChanged(dest, url, validators)
+		if err == nil {
+			return newValidators, isChanged, nil
+		}
+	}
+
+	return Validators{}, false, err
+}
+
+// downloadIfChanged downloads a url to dest if the file doesn't have the given validators anymore. The body is written
+// aside and moved in place once complete, so that dest is never left half written.
+func downloadIfChanged(dest, url string, validators Validators) (Validators, bool, error) {
+	// Prepare the client and the request
+	client := &http.Client{Timeout: DownloadTimeout}
+	ctx := context.Background()
+	dlReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return false, err
+		return Validators{}, false, err
+	}
+
+	if validators.ETag != "" {
+		dlReq.Header.Set("If-None-Match", validators.ETag)
+	}
+
+	if validators.LastModified != "" {
+		dlReq.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	// Do the request
+	resp, err := client.Do(dlReq)
+	if err != nil {
+		return Validators{}, false, err
+	}
+
+	newValidators := Validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+
+	if resp.StatusCode == http.StatusNotModified {
+		// A server may not send the validators again
+		if newValidators == (Validators{}) {
+			newValidators = validators
+		}
+
+		return newValidators, false, resp.Body.Close()
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return Validators{}, false, errors.Join(
+			fmt.Errorf("url returned code %d", resp.StatusCode), //nolint:goerr113
+			resp.Body.Close(),
+		)
+	}
+
+	// Create the file the body is written to
+	partPath := dest + ".part"
+	partFile, err := os.Create(partPath)
+	if err != nil {
+		return Validators{}, false, errors.Join(err, resp.Body.Close())
+	}
+
+	// Copy the content of the body to it
+	_, err = io.Copy(partFile, resp.Body)
+	if err != nil {
+		return Validators{}, false, errors.Join(err, resp.Body.Close(), partFile.Close())
+	}
+
+	// Close the file and the body
+	err = errors.Join(partFile.Close(), resp.Body.Close())
+	if err != nil {
+		return Validators{}, false, err
+	}
+
+	return newValidators, true, os.Rename(partPath, dest)
+}
+
+// HashFile returns the sha512 hash of a file as a hex string.
+func HashFile(fileToHash string) (string, error) {
+	// Open the file to hash
+	file, err := os.Open(fileToHash)
+	if err != nil {
+		return "", err
 	}
 
 	// Create the hash of the file
 	hash := sha512.New()
 	if _, err := io.Copy(hash, file); err != nil {
-		return false, err
+		return "", errors.Join(err, file.Close())
 	}
 
 	// Close the file
 	if err = file.Close(); err != nil {
-		return false, err
+		return "", err
 	}
 
 	// Convert the hash to a hex string
-	hexHash := hex.EncodeToString(hash.Sum(nil))
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Verify verifies the sha512 hash of a file.
+func Verify(fileToVerify, supposedHash string) (bool, error) {
+	hexHash, err := HashFile(fileToVerify)
+	if err != nil {
+		return false, err
+	}
 
 	// Compare the hashes
 	if hexHash == supposedHash {
@@ -244,19 +408,19 @@ func Copy(src, dst string, overwrite bool) error {
 	// Create the destination file
 	destination, err := os.Create(dst)
 	if err != nil {
-		return err
+		return errors.Join(err, source.Close())
 	}
 
 	// Copy the source file into the destination file
 	_, err = io.Copy(destination, source)
 	if err != nil {
-		return err
+		return errors.Join(err, source.Close(), destination.Close())
 	}
 
 	// Close the source file
 	err = source.Close()
 	if err != nil {
-		return err
+		return errors.Join(err, destination.Close())
 	}
 
 	// Close the destination file
@@ -0,0 +1,225 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/redds-be/rpkgm/internal/database"
+	"github.com/redds-be/rpkgm/internal/util"
+)
+
+// Kinds of problems found on an installed path.
+const (
+	ProblemMissing  = "missing"
+	ProblemModified = "modified"
+	ProblemMode     = "permissions changed"
+)
+
+// modeMask keeps the permission bits along with setuid, setgid and sticky, like the recorded modes.
+const modeMask = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// Problem defines a difference between an installed path and what was recorded at install time.
+type Problem struct {
+	Path   string
+	Kind   string
+	Detail string
+}
+
+// Check compares an installed path against its manifest entry and returns what differs.
+func Check(root string, entry database.ManifestEntry) ([]Problem, error) { //nolint:cyclop
+	path := filepath.Join(root, entry.Path)
+
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Problem{{Path: entry.Path, Kind: ProblemMissing}}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+
+	switch entry.Type {
+	case database.EntryDir:
+		if !info.IsDir() {
+			return []Problem{{Path: entry.Path, Kind: ProblemModified, Detail: "not a directory anymore"}}, nil
+		}
+	case database.EntryFile:
+		if !info.Mode().IsRegular() {
+			return []Problem{{Path: entry.Path, Kind: ProblemModified, Detail: "not a regular file anymore"}}, nil
+		}
+
+		if info.Size() != entry.Size {
+			problems = append(problems, Problem{
+				Path:   entry.Path,
+				Kind:   ProblemModified,
+				Detail: fmt.Sprintf("size %d, expected %d", info.Size(), entry.Size),
+			})
+		} else {
+			isOk, err := util.Verify(path, entry.Sha512)
+			if err != nil {
+				return nil, err
+			}
+
+			if !isOk {
+				problems = append(problems, Problem{Path: entry.Path, Kind: ProblemModified, Detail: "sha512 mismatch"})
+			}
+		}
+	case database.EntrySymlink:
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return []Problem{{Path: entry.Path, Kind: ProblemModified, Detail: "not a symlink anymore"}}, nil
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if target != entry.Target {
+			problems = append(problems, Problem{
+				Path:   entry.Path,
+				Kind:   ProblemModified,
+				Detail: fmt.Sprintf("points to %s, expected %s", target, entry.Target),
+			})
+		}
+
+		// The mode of a symlink is meaningless
+		return problems, nil
+	}
+
+	mode := uint32(info.Mode() & modeMask)
+	if mode != entry.Mode {
+		problems = append(problems, Problem{
+			Path:   entry.Path,
+			Kind:   ProblemMode,
+			Detail: fmt.Sprintf("%04o, expected %04o", mode, entry.Mode),
+		})
+	}
+
+	return problems, nil
+}
+
+// verifyPkg checks every recorded path of a package and reports the problems, it returns whether the package is intact.
+func verifyPkg(name, root string, verbose bool, store *database.Store) bool {
+	entries, err := store.GetManifest(name)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not get the files installed by %s. Error: %s", name, err)
+		util.Exit(1)
+	}
+
+	if len(entries) == 0 {
+		util.Display(os.Stdout, false, "%s: no files recorded, it was installed before rpkgm recorded files, skipping.", name)
+
+		return true
+	}
+
+	isIntact := true
+
+	for _, entry := range entries {
+		problems, err := Check(root, entry)
+		if err != nil {
+			util.Display(os.Stderr, true, "%s: rpkgm could not check %s. Error: %s", name, entry.Path, err)
+
+			isIntact = false
+
+			continue
+		}
+
+		for _, problem := range problems {
+			if problem.Detail == "" {
+				util.Display(os.Stdout, true, "%s: %s %s", name, problem.Path, problem.Kind)
+			} else {
+				util.Display(os.Stdout, true, "%s: %s %s (%s)", name, problem.Path, problem.Kind, problem.Detail)
+			}
+
+			isIntact = false
+		}
+	}
+
+	if isIntact && verbose {
+		util.Display(os.Stdout, false, "%s: %d paths OK.", name, len(entries))
+	}
+
+	return isIntact
+}
+
+// Decide decides what to do based on the given packages, every installed package is verified if none is given.
+// The files are checked as installed into root.
+func Decide(dbLocation database.Location, root string, packageList []string, verbose bool) {
+	// Connect to the database
+	store, err := database.Open(dbLocation)
+	if err != nil {
+		util.Display(os.Stderr, true, "rpkgm could not connect to the database. Error: %s", err)
+		util.Exit(1)
+	}
+
+	// Verify every installed package if none were given
+	if len(packageList) == 0 {
+		installed, err := store.GetInstalledPkgInfo()
+		if err != nil {
+			util.Display(os.Stderr, true, "rpkgm could not get the installed packages. Error: %s", err)
+			util.Exit(1)
+		}
+
+		for _, pkgInfo := range installed {
+			packageList = append(packageList, pkgInfo.Name)
+		}
+	}
+
+	var broken int
+
+	for _, pkgName := range packageList {
+		isInstalled, err := store.IsInstalled(pkgName)
+		if err != nil || !isInstalled {
+			util.Display(os.Stderr, false, "%s is not installed.", pkgName)
+
+			broken++
+
+			continue
+		}
+
+		if !verifyPkg(pkgName, root, verbose, store) {
+			broken++
+		}
+	}
+
+	// Close the database connection
+	err = store.CloseDBConnection()
+	if err != nil {
+		util.Display(
+			os.Stderr,
+			true,
+			"rpkgm could not close the connection to the database. Error: %s",
+			err,
+		)
+		util.Exit(1)
+	}
+
+	// Exit with an error so that cron and scripts notice
+	if broken > 0 {
+		util.Display(os.Stderr, true, "%d of %d package(s) failed verification.", broken, len(packageList))
+		util.Exit(1)
+	}
+
+	util.Display(os.Stdout, false, "%d package(s) verified, no problems found.", len(packageList))
+}
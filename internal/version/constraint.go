@@ -0,0 +1,195 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package version
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidConstraint is returned when a dependency constraint can't be parsed.
+var ErrInvalidConstraint = errors.New("invalid dependency constraint")
+
+// validName matches the allowed package names.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// IsValidName reports whether a package name is allowed.
+func IsValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// operators lists the supported operators, the longest ones first so they are matched first.
+var operators = []string{">=", "<=", "!=", "==", "=", ">", "<"}
+
+// Constraint defines a dependency on a package, optionally restricted to some versions (ex: zlib>=1.2.13).
+type Constraint struct {
+	Name    string
+	Op      string
+	Version string
+}
+
+// ParseConstraint parses a single dependency constraint such as zlib, zlib>=1.2.13, openssl<3 or foo=1.4.*.
+func ParseConstraint(raw string) (Constraint, error) { //nolint:cyclop
+	str := strings.TrimSpace(raw)
+
+	// No operator, the constraint is a bare package name
+	idx := strings.IndexAny(str, "<>=!")
+	if idx < 0 {
+		if !validName.MatchString(str) {
+			return Constraint{}, fmt.Errorf("%w: %q is not a valid package name", ErrInvalidConstraint, raw)
+		}
+
+		return Constraint{Name: str}, nil
+	}
+
+	constraint := Constraint{Name: str[:idx]}
+	if !validName.MatchString(constraint.Name) {
+		return Constraint{}, fmt.Errorf("%w: %q has an invalid package name", ErrInvalidConstraint, raw)
+	}
+
+	// Find the operator
+	rest := str[idx:]
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			constraint.Op = op
+			constraint.Version = rest[len(op):]
+
+			break
+		}
+	}
+
+	if constraint.Op == "" {
+		return Constraint{}, fmt.Errorf("%w: %q has an unknown operator", ErrInvalidConstraint, raw)
+	}
+
+	// == is just an alias for =
+	if constraint.Op == "==" {
+		constraint.Op = "="
+	}
+
+	// Wildcards are only allowed at the end of the version, with = and !=
+	prefix, isWildcard := strings.CutSuffix(constraint.Version, ".*")
+	if isWildcard && constraint.Op != "=" && constraint.Op != "!=" {
+		return Constraint{}, fmt.Errorf("%w: %q uses a wildcard with %s", ErrInvalidConstraint, raw, constraint.Op)
+	}
+
+	if strings.Contains(prefix, "*") {
+		return Constraint{}, fmt.Errorf("%w: %q has a misplaced wildcard", ErrInvalidConstraint, raw)
+	}
+
+	// Make sure the version is a valid one
+	if _, err := Parse(prefix); err != nil {
+		return Constraint{}, fmt.Errorf("%w: %q: %w", ErrInvalidConstraint, raw, err)
+	}
+
+	return constraint, nil
+}
+
+// ParseConstraints parses a space-separated list of dependency constraints.
+func ParseConstraints(deps string) ([]Constraint, error) {
+	fields := strings.Fields(deps)
+	constraints := make([]Constraint, 0, len(fields))
+
+	for _, field := range fields {
+		constraint, err := ParseConstraint(field)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, constraint)
+	}
+
+	return constraints, nil
+}
+
+// String returns the constraint as it would be written in a dependencies list.
+func (constraint Constraint) String() string {
+	return constraint.Name + constraint.Op + constraint.Version
+}
+
+// IsVersioned reports whether the constraint restricts the versions of the package.
+func (constraint Constraint) IsVersioned() bool {
+	return constraint.Op != ""
+}
+
+// Allows reports whether a given version of the package satisfies the constraint.
+func (constraint Constraint) Allows(rawVersion string) (bool, error) {
+	// A bare name is satisfied by any version
+	if !constraint.IsVersioned() {
+		return true, nil
+	}
+
+	ver, err := Parse(rawVersion)
+	if err != nil {
+		return false, err
+	}
+
+	// Wildcards compare only the given upstream segments
+	if prefix, isWildcard := strings.CutSuffix(constraint.Version, ".*"); isWildcard {
+		wanted, err := Parse(prefix)
+		if err != nil {
+			return false, err
+		}
+
+		matches := ver.Epoch == wanted.Epoch && hasUpstreamPrefix(ver.Upstream, wanted.Upstream)
+
+		return matches == (constraint.Op == "="), nil
+	}
+
+	wanted, err := Parse(constraint.Version)
+	if err != nil {
+		return false, err
+	}
+
+	res := ver.Compare(wanted)
+
+	switch constraint.Op {
+	case "=":
+		return res == 0, nil
+	case "!=":
+		return res != 0, nil
+	case ">":
+		return res > 0, nil
+	case ">=":
+		return res >= 0, nil
+	case "<":
+		return res < 0, nil
+	case "<=":
+		return res <= 0, nil
+	default:
+		return false, fmt.Errorf("%w: unknown operator %s", ErrInvalidConstraint, constraint.Op)
+	}
+}
+
+// hasUpstreamPrefix reports whether the first segments of an upstream version are the ones of prefix (1.4.2 has 1.4).
+func hasUpstreamPrefix(upstream, prefix string) bool {
+	segments := strings.Split(upstream, ".")
+	prefixSegments := strings.Split(prefix, ".")
+
+	if len(segments) < len(prefixSegments) {
+		return false
+	}
+
+	for idx, prefixSegment := range prefixSegments {
+		if compareSegment(segments[idx], prefixSegment) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
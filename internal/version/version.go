@@ -0,0 +1,280 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package version
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidVersion is returned when a version string can't be parsed.
+var ErrInvalidVersion = errors.New("invalid version")
+
+// Version defines a parsed package version.
+//
+// The accepted format is [epoch:]upstream[-pre-release|~pre-release][-rN][+build], for example:
+// 1.2.3, v1.2.3, 2:1.0, 1.0.0-rc.1, 1.0~beta2, 1.2.3-r2, 1.0.0-alpha+exp.sha.5114f85.
+type Version struct {
+	Epoch    int
+	Upstream string
+	Pre      string
+	Revision int
+	Build    string
+}
+
+// Parse parses a version string.
+func Parse(raw string) (Version, error) { //nolint:cyclop
+	var ver Version
+
+	str := strings.TrimSpace(raw)
+	if str == "" {
+		return Version{}, fmt.Errorf("%w: empty version", ErrInvalidVersion)
+	}
+
+	// Versions can't contain whitespaces or characters used by dependency constraints
+	if strings.ContainsFunc(str, func(r rune) bool { return unicode.IsSpace(r) || strings.ContainsRune("<>=!,", r) }) {
+		return Version{}, fmt.Errorf("%w: %q contains forbidden characters", ErrInvalidVersion, raw)
+	}
+
+	// Get the epoch (Debian/RPM style), if any
+	if before, after, found := strings.Cut(str, ":"); found {
+		epoch, err := strconv.Atoi(before)
+		if err != nil || epoch < 0 {
+			return Version{}, fmt.Errorf("%w: %q has an invalid epoch", ErrInvalidVersion, raw)
+		}
+		ver.Epoch = epoch
+		str = after
+	}
+
+	// Get the build metadata (semver style), it is ignored when comparing
+	if before, after, found := strings.Cut(str, "+"); found {
+		ver.Build = after
+		str = before
+	}
+
+	// Get the revision (-rN), if any
+	if idx := strings.LastIndex(str, "-r"); idx > 0 {
+		revision, err := strconv.Atoi(str[idx+2:])
+		if err == nil && revision >= 0 {
+			ver.Revision = revision
+			str = str[:idx]
+		}
+	}
+
+	// Get the pre-release, either semver style (-rc.1) or Debian style (~rc1)
+	if idx := strings.IndexAny(str, "-~"); idx >= 0 {
+		ver.Pre = str[idx+1:]
+		str = str[:idx]
+
+		if ver.Pre == "" {
+			return Version{}, fmt.Errorf("%w: %q has an empty pre-release", ErrInvalidVersion, raw)
+		}
+	}
+
+	// Allow the common v1.2.3 notation
+	if len(str) > 1 && (str[0] == 'v' || str[0] == 'V') && unicode.IsDigit(rune(str[1])) {
+		str = str[1:]
+	}
+
+	if str == "" {
+		return Version{}, fmt.Errorf("%w: %q has no upstream version", ErrInvalidVersion, raw)
+	}
+	ver.Upstream = str
+
+	return ver, nil
+}
+
+// String returns the canonical string of a version.
+func (ver Version) String() string {
+	var builder strings.Builder
+
+	if ver.Epoch > 0 {
+		builder.WriteString(fmt.Sprintf("%d:", ver.Epoch))
+	}
+
+	builder.WriteString(ver.Upstream)
+
+	if ver.Pre != "" {
+		builder.WriteString("-" + ver.Pre)
+	}
+
+	if ver.Revision > 0 {
+		builder.WriteString(fmt.Sprintf("-r%d", ver.Revision))
+	}
+
+	if ver.Build != "" {
+		builder.WriteString("+" + ver.Build)
+	}
+
+	return builder.String()
+}
+
+// Compare returns -1 if ver is older than other, 1 if ver is newer than other and 0 if they are the same.
+func (ver Version) Compare(other Version) int {
+	// The epoch always wins
+	if ver.Epoch != other.Epoch {
+		return cmp.Compare(ver.Epoch, other.Epoch)
+	}
+
+	// Then the upstream version
+	if res := compareUpstream(ver.Upstream, other.Upstream); res != 0 {
+		return res
+	}
+
+	// Then the pre-release, a version without a pre-release is newer than one with a pre-release
+	switch {
+	case ver.Pre == "" && other.Pre != "":
+		return 1
+	case ver.Pre != "" && other.Pre == "":
+		return -1
+	case ver.Pre != other.Pre:
+		if res := comparePre(ver.Pre, other.Pre); res != 0 {
+			return res
+		}
+	}
+
+	// And finally the revision, the build metadata is ignored
+	return cmp.Compare(ver.Revision, other.Revision)
+}
+
+// Compare parses and compares two version strings, see Version.Compare.
+func Compare(first, second string) (int, error) {
+	firstVer, err := Parse(first)
+	if err != nil {
+		return 0, err
+	}
+
+	secondVer, err := Parse(second)
+	if err != nil {
+		return 0, err
+	}
+
+	return firstVer.Compare(secondVer), nil
+}
+
+// compareUpstream compares two upstream versions segment by segment (missing segments count as 0).
+func compareUpstream(first, second string) int {
+	firstSegs := strings.Split(first, ".")
+	secondSegs := strings.Split(second, ".")
+
+	for idx := 0; idx < max(len(firstSegs), len(secondSegs)); idx++ {
+		firstSeg, secondSeg := "0", "0"
+		if idx < len(firstSegs) {
+			firstSeg = firstSegs[idx]
+		}
+		if idx < len(secondSegs) {
+			secondSeg = secondSegs[idx]
+		}
+
+		if res := compareSegment(firstSeg, secondSeg); res != 0 {
+			return res
+		}
+	}
+
+	return 0
+}
+
+// compareSegment compares two segments by splitting them into runs of digits and non-digits (rpmvercmp style).
+func compareSegment(first, second string) int {
+	for first != "" || second != "" {
+		var firstRun, secondRun string
+		firstRun, first = nextRun(first)
+		secondRun, second = nextRun(second)
+
+		firstNum, firstErr := strconv.ParseUint(firstRun, 10, 64)
+		secondNum, secondErr := strconv.ParseUint(secondRun, 10, 64)
+
+		switch {
+		// Both runs are numeric, compare them as numbers
+		case firstErr == nil && secondErr == nil:
+			if firstNum != secondNum {
+				return cmp.Compare(firstNum, secondNum)
+			}
+		// A numeric run is newer than an alphabetic one (1.0a < 1.01)
+		case firstErr == nil && secondRun != "":
+			return 1
+		case secondErr == nil && firstRun != "":
+			return -1
+		// A missing run is older than anything else
+		case firstRun == "":
+			return -1
+		case secondRun == "":
+			return 1
+		default:
+			if res := strings.Compare(firstRun, secondRun); res != 0 {
+				return res
+			}
+		}
+	}
+
+	return 0
+}
+
+// nextRun returns the first run of digits or non-digits of a string and the rest of the string.
+func nextRun(str string) (string, string) {
+	if str == "" {
+		return "", ""
+	}
+
+	isDigit := unicode.IsDigit(rune(str[0]))
+	end := 1
+	for end < len(str) && unicode.IsDigit(rune(str[end])) == isDigit {
+		end++
+	}
+
+	return str[:end], str[end:]
+}
+
+// comparePre compares two pre-releases following the semver rules.
+func comparePre(first, second string) int {
+	firstIDs := strings.FieldsFunc(first, isPreSeparator)
+	secondIDs := strings.FieldsFunc(second, isPreSeparator)
+
+	for idx := 0; idx < min(len(firstIDs), len(secondIDs)); idx++ {
+		firstNum, firstErr := strconv.ParseUint(firstIDs[idx], 10, 64)
+		secondNum, secondErr := strconv.ParseUint(secondIDs[idx], 10, 64)
+
+		switch {
+		case firstErr == nil && secondErr == nil:
+			if firstNum != secondNum {
+				return cmp.Compare(firstNum, secondNum)
+			}
+		// Numeric identifiers have a lower precedence than alphanumeric ones
+		case firstErr == nil:
+			return -1
+		case secondErr == nil:
+			return 1
+		default:
+			// rc1 vs rc10 should be compared numerically, so reuse the segment comparison
+			if res := compareSegment(firstIDs[idx], secondIDs[idx]); res != 0 {
+				return res
+			}
+		}
+	}
+
+	// A larger set of identifiers has a higher precedence
+	return cmp.Compare(len(firstIDs), len(secondIDs))
+}
+
+// isPreSeparator reports whether a rune separates pre-release identifiers.
+func isPreSeparator(r rune) bool {
+	return r == '.' || r == '-' || r == '~' || r == '_'
+}
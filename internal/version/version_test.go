@@ -0,0 +1,137 @@
+//    rpkgm, redd's package manager.
+//    Copyright (C) 2024 redd
+//
+//    This program is free software: you can redistribute it and/or modify
+//    it under the terms of the GNU General Public License as published by
+//    the Free Software Foundation, either version 3 of the License, or
+//    (at your option) any later version.
+//
+//    This program is distributed in the hope that it will be useful,
+//    but WITHOUT ANY WARRANTY; without even the implied warranty of
+//    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//    GNU General Public License for more details.
+//
+//    You should have received a copy of the GNU General Public License
+//    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package version_test
+
+import (
+	"testing"
+
+	"github.com/redds-be/rpkgm/internal/version"
+)
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		first, second string
+		want          int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.2", "1.10", -1},
+		{"1.10", "1.9", 1},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.0a", "1.0b", -1},
+		{"1:1.0", "2.0", 1},
+		{"0:2.0", "2.0", 0},
+		{"1.0-rc.1", "1.0", -1},
+		{"1.0~beta2", "1.0", -1},
+		{"1.0-alpha", "1.0-beta", -1},
+		{"1.0-rc.2", "1.0-rc.10", -1},
+		{"1.0-r2", "1.0-r1", 1},
+		{"1.0-r1", "1.0", 1},
+		{"1.0-rc.1-r3", "1.0", -1},
+		{"1.0+build.1", "1.0+build.2", 0},
+	}
+
+	for _, test := range tests {
+		got, err := version.Compare(test.first, test.second)
+		if err != nil {
+			t.Errorf("Compare(%q, %q) returned an error: %s", test.first, test.second, err)
+
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", test.first, test.second, got, test.want)
+		}
+
+		// Comparing the other way around gives the opposite
+		if reversed, _ := version.Compare(test.second, test.first); reversed != -test.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", test.second, test.first, reversed, -test.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, raw := range []string{"", " ", "1.0 2", "1>0", "a:1.0", "-1:1.0", "1.0-", "1.0~"} {
+		if _, err := version.Parse(raw); err == nil {
+			t.Errorf("Parse(%q) returned no error", raw)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		constraint, version string
+		want                bool
+	}{
+		{"zlib", "0.1", true},
+		{"zlib>=1.2.13", "1.2.13", true},
+		{"zlib>=1.2.13", "1.2.9", false},
+		{"zlib>1.2", "1.2", false},
+		{"zlib>1.2", "1.2-r1", true},
+		{"openssl<3", "3.0-rc.1", true},
+		{"openssl<3", "3", false},
+		{"openssl<=3", "3", true},
+		{"foo=1.4", "1.4", true},
+		{"foo==1.4", "1.4", true},
+		{"foo!=1.4", "1.4", false},
+		{"foo=1.4.*", "1.4.2", true},
+		{"foo=1.4.*", "1.4", true},
+		{"foo=1.4.*", "1.40", false},
+		{"foo=1.4.*", "1.5.0", false},
+		{"foo=1.4.*", "1:1.4.2", false},
+		{"foo!=1.4.*", "1.4.2", false},
+		{"foo!=1.4.*", "1.5", true},
+		{"foo>=1:1.0", "2.0", false},
+	}
+
+	for _, test := range tests {
+		constraint, err := version.ParseConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q) returned an error: %s", test.constraint, err)
+
+			continue
+		}
+
+		got, err := constraint.Allows(test.version)
+		if err != nil {
+			t.Errorf("%q.Allows(%q) returned an error: %s", test.constraint, test.version, err)
+
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("%q.Allows(%q) = %t, want %t", test.constraint, test.version, got, test.want)
+		}
+	}
+}
+
+func TestParseConstraintInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, raw := range []string{"", "-foo>1", "foo>>1", "foo>1.*", "foo=1.*.2", "foo=", "foo=*"} {
+		if _, err := version.ParseConstraint(raw); err == nil {
+			t.Errorf("ParseConstraint(%q) returned no error", raw)
+		}
+	}
+}